@@ -2,11 +2,19 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"database/sql"
 	"encoding/json"
 	"flag"
+	"fmt"
+	"io"
+	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -22,21 +30,20 @@ const (
 
 // Setup function to initialize the Fiber app
 func setupRouter() *fiber.App {
-	app := fiber.New()
-
-	// Serve the HTML file (if needed)
-	app.Static("/", "./templates/index.html")
+	app := newApp()
+	go startTaskScheduler(context.Background(), pool, defaultConfig().Scheduler) // Start the task scheduler in a goroutine
+	return app
+}
 
-	// API routes
-	app.Post("/register", registerHandler)
-	app.Post("/login", loginHandler)
-	app.Post("/schedule", scheduleHandler)
-	app.Delete("/api/tasks/delete", deleteTaskHandler)
-	app.Post("/api/tasks/set-enabled", setTaskEnabledHandler)
-	app.Post("/api/tasks", fetchTasksHandler)
+// Fiber app with the API routes but without the task scheduler
+func newTestApp() *fiber.App {
+	return newApp()
+}
 
-	go startTaskScheduler() // Start the task scheduler in a goroutine
-	return app
+// Set the JSON content type and the bearer token of the default test user
+func authorize(req *http.Request) {
+	req.Header.Set("Content-Type", "application/json") // Set content type to JSON
+	req.Header.Set("Authorization", "Bearer "+defaultToken)
 }
 
 // Function to generate a random task name
@@ -54,8 +61,6 @@ func createTask(app *fiber.App, t *testing.T) int {
 	now := time.Now().Unix() // Current Unix timestamp
 	end := now + 10
 	taskBody := map[string]interface{}{
-		"username":     defaultUsername, // Ensure this is defined elsewhere
-		"token":        defaultToken,    // Ensure this is defined elsewhere
 		"name":         randomTaskName(nil),
 		"message":      "This task will be used for testing the flow.",
 		"url":          "http://example.com",
@@ -69,7 +74,7 @@ func createTask(app *fiber.App, t *testing.T) int {
 
 	// Use in-memory app
 	req := httptest.NewRequest("POST", "/schedule", bytes.NewBuffer(body))
-	req.Header.Set("Content-Type", "application/json") // Set content type to JSON
+	authorize(req)
 
 	resp, err := app.Test(req)
 	if err != nil {
@@ -98,10 +103,8 @@ func createTask(app *fiber.App, t *testing.T) int {
 // Helper function to set the task enabled/disabled
 func setTaskEnabled(app *fiber.App, t *testing.T, taskID int, enable bool) {
 	enableBody := map[string]interface{}{
-		"username": defaultUsername, // Ensure this is defined elsewhere
-		"token":    defaultToken,    // Ensure this is defined elsewhere
-		"task_id":  taskID,
-		"enabled":  enable,
+		"task_id": taskID,
+		"enabled": enable,
 	}
 	body, err := json.Marshal(enableBody)
 	if err != nil {
@@ -110,7 +113,7 @@ func setTaskEnabled(app *fiber.App, t *testing.T, taskID int, enable bool) {
 
 	// Use in-memory app
 	req := httptest.NewRequest("POST", "/api/tasks/set-enabled", bytes.NewBuffer(body))
-	req.Header.Set("Content-Type", "application/json") // Set content type to JSON
+	authorize(req)
 
 	resp, err := app.Test(req)
 	if err != nil {
@@ -145,15 +148,9 @@ func setTaskEnabled(app *fiber.App, t *testing.T, taskID int, enable bool) {
 
 // Helper function to fetch tasks
 func fetchTasks(app *fiber.App, t *testing.T) []interface{} {
-	fetchBody := map[string]string{
-		"username": defaultUsername,
-		"token":    defaultToken,
-	}
-	body, _ := json.Marshal(fetchBody)
-
 	// Use in-memory app
-	req := httptest.NewRequest("POST", "/api/tasks", bytes.NewBuffer(body))
-	req.Header.Set("Content-Type", "application/json") // Set content type to JSON
+	req := httptest.NewRequest("POST", "/api/tasks", nil)
+	authorize(req)
 	resp, err := app.Test(req)
 	if err != nil {
 		t.Fatalf("Error making request to in-memory app: %v", err)
@@ -179,9 +176,7 @@ func fetchTasks(app *fiber.App, t *testing.T) []interface{} {
 // Helper function to delete a task
 func deleteTask(app *fiber.App, t *testing.T, taskID int) {
 	deleteBody := map[string]interface{}{
-		"username": defaultUsername, // Ensure this is defined elsewhere
-		"token":    defaultToken,    // Ensure this is defined elsewhere
-		"task_id":  taskID,
+		"task_id": taskID,
 	}
 	body, err := json.Marshal(deleteBody)
 	if err != nil {
@@ -190,7 +185,7 @@ func deleteTask(app *fiber.App, t *testing.T, taskID int) {
 
 	// Use in-memory app
 	req := httptest.NewRequest("DELETE", "/api/tasks/delete", bytes.NewBuffer(body))
-	req.Header.Set("Content-Type", "application/json") // Set content type to JSON
+	authorize(req)
 
 	resp, err := app.Test(req)
 	if err != nil {
@@ -210,8 +205,8 @@ func deleteTask(app *fiber.App, t *testing.T, taskID int) {
 }
 
 func TestTaskFlow(t *testing.T) {
-	InitializeLogger()   // Set up logger
-	app := setupRouter() // Initialize the Fiber app
+	InitializeLogger(defaultConfig().Log) // Set up logger
+	app := setupRouter()                  // Initialize the Fiber app
 	app.Use(LogrusLogger())
 	// Step 1: Create a task
 	taskID := createTask(app, t)
@@ -252,14 +247,1812 @@ func TestTaskFlow(t *testing.T) {
 	}
 }
 
-func TestMain(m *testing.M) {
-	// Parse command-line flags
-	flag.Parse()
+// Helper function to post a task definition to /schedule
+func postSchedule(app *fiber.App, t *testing.T, taskBody map[string]interface{}) (int, map[string]interface{}) {
+	body, _ := json.Marshal(taskBody)
 
-	// Setup code can go here, such as initializing a database connection
-	code := m.Run() // Run tests
+	req := httptest.NewRequest("POST", "/schedule", bytes.NewBuffer(body))
+	authorize(req)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Error making request to in-memory app: %v", err)
+	}
+
+	var response map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatalf("Error parsing response: %v", err)
+	}
+	return resp.StatusCode, response
+}
+
+func TestCronSchedule(t *testing.T) {
+	app := newTestApp()
+
+	now := time.Now().Unix()
+
+	// An invalid cron expression is rejected
+	status, _ := postSchedule(app, t, map[string]interface{}{
+		"name":     randomTaskName(nil),
+		"url":      "http://example.com",
+		"schedule": "61 * * * *",
+		"end":      now + 3600,
+	})
+	if status != fiber.StatusUnprocessableEntity {
+		t.Errorf("Expected status Unprocessable Entity for invalid schedule, got: %v", status)
+	}
+
+	// A descriptor schedule starts at the next occurrence and becomes recurring
+	status, response := postSchedule(app, t, map[string]interface{}{
+		"name":     randomTaskName(nil),
+		"url":      "http://example.com",
+		"schedule": "@hourly",
+		"end":      now + 7200,
+	})
+	if status != fiber.StatusOK {
+		t.Fatalf("Expected status OK for cron task creation, got: %v", status)
+	}
+	task := response["task"].(map[string]interface{})
+	start := int64(task["start"].(float64))
+	if start <= now || start%3600 != 0 {
+		t.Errorf("Expected start at the next full hour, got: %d", start)
+	}
+	if task["is_recurring"] != true {
+		t.Error("Expected cron task to be recurring")
+	}
+	deleteTask(app, t, int(task["task_id"].(float64)))
+}
+
+func TestTimezoneSchedule(t *testing.T) {
+	app := newTestApp()
+
+	now := time.Now().Unix()
+
+	// Unknown time zones are rejected
+	status, _ := postSchedule(app, t, map[string]interface{}{
+		"name":     randomTaskName(nil),
+		"url":      "http://example.com",
+		"schedule": "0 8 * * *",
+		"timezone": "Mars/Olympus_Mons",
+		"end":      now + 86400*2,
+	})
+	if status != fiber.StatusUnprocessableEntity {
+		t.Errorf("Expected status Unprocessable Entity for invalid timezone, got: %v", status)
+	}
+
+	// The first run is 08:00 wall clock time in the task's zone
+	status, response := postSchedule(app, t, map[string]interface{}{
+		"name":     randomTaskName(nil),
+		"url":      "http://example.com",
+		"schedule": "0 8 * * *",
+		"timezone": "Europe/Berlin",
+		"end":      now + 86400*2,
+	})
+	if status != fiber.StatusOK {
+		t.Fatalf("Expected status OK for zoned task creation, got: %v", status)
+	}
+	task := response["task"].(map[string]interface{})
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	start := time.Unix(int64(task["start"].(float64)), 0).In(berlin)
+	if start.Hour() != 8 || start.Minute() != 0 {
+		t.Errorf("Expected start at 08:00 Berlin time, got: %s", start)
+	}
+	deleteTask(app, t, int(task["task_id"].(float64)))
+}
+
+// Helper function to fetch the run history of a task
+func fetchRuns(app *fiber.App, t *testing.T, taskID int, query string) map[string]interface{} {
+	req := httptest.NewRequest("GET", "/api/tasks/"+strconv.Itoa(taskID)+"/runs?"+query, nil)
+	authorize(req)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Error making request to in-memory app: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("Expected status OK for fetching runs, got: %v", resp.StatusCode)
+	}
+
+	var response map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatalf("Error parsing response: %v", err)
+	}
+	return response
+}
+
+func TestTaskRuns(t *testing.T) {
+	app := newTestApp()
+
+	now := time.Now().Unix()
+	status, response := postSchedule(app, t, map[string]interface{}{
+		"name":  randomTaskName(nil),
+		"url":   "http://example.com",
+		"start": now + 3600,
+		"end":   now + 7200,
+	})
+	if status != fiber.StatusOK {
+		t.Fatalf("Expected status OK for task creation, got: %v", status)
+	}
+	task := response["task"].(map[string]interface{})
+	taskID := int(task["task_id"].(float64))
+	userID := int(task["user_id"].(float64))
+	defer deleteTask(app, t, taskID)
+
+	for i, outcome := range []string{runStatusSuccess, runStatusFailure, runStatusSuccess} {
+		run := TaskRun{TaskID: taskID, UserID: userID, StartedAt: now + int64(i), Status: outcome, Attempt: 1}
+		if _, err := recordRun(run); err != nil {
+			t.Fatalf("Error recording run: %v", err)
+		}
+	}
+	// Runs past the retention period are pruned
+	if _, err := recordRun(TaskRun{TaskID: taskID, UserID: userID, StartedAt: now - int64(runRetentionDays+1)*86400, Status: runStatusSuccess}); err != nil {
+		t.Fatalf("Error recording run: %v", err)
+	}
+	if _, err := pruneRuns(time.Now()); err != nil {
+		t.Fatalf("Error pruning runs: %v", err)
+	}
+
+	page := fetchRuns(app, t, taskID, "limit=2")
+	if page["total"].(float64) != 3 || len(page["runs"].([]interface{})) != 2 {
+		t.Errorf("Expected 2 of 3 runs, got: %v", page)
+	}
+	failed := fetchRuns(app, t, taskID, "status="+runStatusFailure)
+	if failed["total"].(float64) != 1 {
+		t.Errorf("Expected 1 failed run, got: %v", failed)
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	app := newTestApp()
+
+	// The endpoint fails twice before succeeding
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	now := time.Now().Unix()
+	status, _ := postSchedule(app, t, map[string]interface{}{
+		"name":  randomTaskName(nil),
+		"url":   server.URL,
+		"end":   now + 7200,
+		"retry": map[string]interface{}{"max_attempts": 20},
+	})
+	if status != fiber.StatusUnprocessableEntity {
+		t.Errorf("Expected status Unprocessable Entity for invalid retry policy, got: %v", status)
+	}
+
+	status, response := postSchedule(app, t, map[string]interface{}{
+		"name":    randomTaskName(nil),
+		"url":     server.URL,
+		"start":   now + 3600,
+		"end":     now + 7200,
+		"enabled": true,
+		"retry":   map[string]interface{}{"max_attempts": 4, "initial_delay_ms": 10, "jitter": 0.5},
+	})
+	if status != fiber.StatusOK {
+		t.Fatalf("Expected status OK for task creation, got: %v", status)
+	}
+	taskID := int(response["task"].(map[string]interface{})["task_id"].(float64))
+
+	task, err := scanTask(db.QueryRow("SELECT "+taskColumns+" FROM tasks WHERE id = ?", taskID))
+	if err != nil {
+		t.Fatalf("Error loading task: %v", err)
+	}
+	executeTask(task) // One-shot, so the task is deleted afterwards
+
+	var attempts int
+	var lastStatus string
+	err = db.QueryRow("SELECT COUNT(*) FROM task_runs WHERE task_id = ?", taskID).Scan(&attempts)
+	if err != nil {
+		t.Fatalf("Error counting runs: %v", err)
+	}
+	if err := db.QueryRow("SELECT status FROM task_runs WHERE task_id = ? ORDER BY attempt DESC LIMIT 1", taskID).Scan(&lastStatus); err != nil {
+		t.Fatalf("Error loading last run: %v", err)
+	}
+	if attempts != 3 || lastStatus != runStatusSuccess {
+		t.Errorf("Expected 3 attempts ending in success, got %d ending in %s", attempts, lastStatus)
+	}
+}
+
+func TestRetriesStopWhenTaskDisabled(t *testing.T) {
+	app := newTestApp()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	status, response := postSchedule(app, t, map[string]interface{}{
+		"name":         randomTaskName(nil),
+		"url":          server.URL,
+		"interval":     3600,
+		"start":        time.Now().Unix() + 3600,
+		"is_recurring": true,
+		"enabled":      true,
+		"retry":        map[string]interface{}{"max_attempts": 5, "initial_delay_ms": 60000},
+	})
+	if status != fiber.StatusOK {
+		t.Fatalf("Expected status OK for task creation, got: %v %v", status, response)
+	}
+	taskID := int(response["task"].(map[string]interface{})["task_id"].(float64))
+	defer deleteTask(app, t, taskID)
+	task, err := scanTask(db.QueryRow("SELECT "+taskColumns+" FROM tasks WHERE id = ?", taskID))
+	if err != nil {
+		t.Fatalf("Error loading task: %v", err)
+	}
+
+	// Pausing the task ends the execution waiting a minute to retry
+	done := make(chan struct{})
+	go func() {
+		executeTask(task)
+		close(done)
+	}()
+	var runs int
+	for runs == 0 {
+		time.Sleep(10 * time.Millisecond)
+		db.QueryRow("SELECT COUNT(*) FROM task_runs WHERE task_id = ?", taskID).Scan(&runs)
+	}
+	setTaskEnabled(app, t, taskID, false)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected disabling the task to stop its retries")
+	}
+	db.QueryRow("SELECT COUNT(*) FROM task_runs WHERE task_id = ?", taskID).Scan(&runs)
+	if runs != 1 {
+		t.Errorf("Expected a single attempt, got: %d", runs)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	policy := RetryPolicy{InitialDelayMs: 1000, MaxDelayMs: 5000, Jitter: 0.5}.withDefaults()
+	for i := 0; i < 100; i++ {
+		if d := policy.delay(1); d < 500*time.Millisecond || d > 1500*time.Millisecond {
+			t.Fatalf("Expected the first delay within the jitter, got: %s", d)
+		}
+		// Jitter never pushes a delay past the cap
+		if d := policy.delay(10); d > 5*time.Second {
+			t.Fatalf("Expected delays capped at 5s, got: %s", d)
+		}
+	}
+}
+
+func TestRetryableErrors(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3}.withDefaults()
+
+	// Connection errors are transient
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+	run := runAttempt(context.Background(), Task{ID: -1, URL: server.URL, Method: "GET"}, 1)
+	if run.StatusCode != 0 || !policy.retryable(run) {
+		t.Errorf("Expected a connection error to be retried, got: %+v", run)
+	}
+
+	// A request that cannot be built fails the same way on every attempt
+	for _, task := range []Task{
+		{ID: -1, URL: "http://[::1", Method: "GET"},
+		{ID: -1, URL: server.URL, Method: "POST", Body: "{{.Missing}}"},
+	} {
+		run := runAttempt(context.Background(), task, 1)
+		if run.Error == "" || policy.retryable(run) {
+			t.Errorf("Expected a build error not to be retried, got: %+v", run)
+		}
+	}
+}
+
+func TestRequestDefinition(t *testing.T) {
+	app := newTestApp()
+
+	// Capture the request sent by the task
+	var received *http.Request
+	var receivedBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received, receivedBody = r, string(body)
+	}))
+	defer server.Close()
+
+	now := time.Now().Unix()
+	status, _ := postSchedule(app, t, map[string]interface{}{
+		"name":   randomTaskName(nil),
+		"url":    server.URL,
+		"method": "TRACE",
+		"end":    now + 7200,
+	})
+	if status != fiber.StatusUnprocessableEntity {
+		t.Errorf("Expected status Unprocessable Entity for unsupported method, got: %v", status)
+	}
+
+	name := randomTaskName(nil)
+	status, response := postSchedule(app, t, map[string]interface{}{
+		"name":    name,
+		"message": "hello",
+		"url":     server.URL + "/hook?a=1",
+		"method":  "post",
+		"headers": map[string]string{"Authorization": "Bearer secret"},
+		"query":   map[string]string{"b": "2"},
+		"body":    `{"name": {{json .Name}}, "message": {{json .Message}}}`,
+		"start":   now + 3600,
+		"end":     now + 7200,
+	})
+	if status != fiber.StatusOK {
+		t.Fatalf("Expected status OK for task creation, got: %v", status)
+	}
+	taskID := int(response["task"].(map[string]interface{})["task_id"].(float64))
+
+	task, err := scanTask(db.QueryRow("SELECT "+taskColumns+" FROM tasks WHERE id = ?", taskID))
+	if err != nil {
+		t.Fatalf("Error loading task: %v", err)
+	}
+	executeTask(task) // One-shot, so the task is deleted afterwards
+
+	if received == nil {
+		t.Fatal("Expected the task to reach the server")
+	}
+	if received.Method != http.MethodPost || received.URL.Query().Get("a") != "1" || received.URL.Query().Get("b") != "2" {
+		t.Errorf("Unexpected request line: %s %s", received.Method, received.URL)
+	}
+	if received.Header.Get("Authorization") != "Bearer secret" || received.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("Unexpected headers: %v", received.Header)
+	}
+	var got map[string]string
+	if err := json.Unmarshal([]byte(receivedBody), &got); err != nil || got["name"] != name || got["message"] != "hello" {
+		t.Errorf("Unexpected rendered body: %s", receivedBody)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	app := newTestApp()
+
+	// The endpoint answers slower than the task allows
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(2 * time.Second)
+	}))
+	defer server.Close()
+
+	now := time.Now().Unix()
+	status, _ := postSchedule(app, t, map[string]interface{}{
+		"name":    randomTaskName(nil),
+		"url":     server.URL,
+		"timeout": int64(clientSettings.MaxTimeout/time.Second) + 1,
+		"end":     now + 7200,
+	})
+	if status != fiber.StatusUnprocessableEntity {
+		t.Errorf("Expected status Unprocessable Entity for timeout above the maximum, got: %v", status)
+	}
+
+	status, response := postSchedule(app, t, map[string]interface{}{
+		"name":    randomTaskName(nil),
+		"url":     server.URL,
+		"timeout": 1,
+		"start":   now + 3600,
+		"end":     now + 7200,
+	})
+	if status != fiber.StatusOK {
+		t.Fatalf("Expected status OK for task creation, got: %v", status)
+	}
+	taskID := int(response["task"].(map[string]interface{})["task_id"].(float64))
+
+	task, err := scanTask(db.QueryRow("SELECT "+taskColumns+" FROM tasks WHERE id = ?", taskID))
+	if err != nil {
+		t.Fatalf("Error loading task: %v", err)
+	}
+	executeTask(task) // One-shot, so the task is deleted afterwards
+
+	var runStatus string
+	if err := db.QueryRow("SELECT status FROM task_runs WHERE task_id = ?", taskID).Scan(&runStatus); err != nil {
+		t.Fatalf("Error loading run: %v", err)
+	}
+	if runStatus != runStatusTimeout {
+		t.Errorf("Expected run status %s, got: %s", runStatusTimeout, runStatus)
+	}
+}
+
+func TestOverlapPolicy(t *testing.T) {
+	app := newTestApp()
+
+	// The endpoint is slower than the gap between two occurrences
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	now := time.Now().Unix()
+	status, response := postSchedule(app, t, map[string]interface{}{
+		"name":         randomTaskName(nil),
+		"url":          server.URL,
+		"interval":     3600,
+		"is_recurring": true,
+		"start":        now + 3600,
+		"end":          now + 7200,
+	})
+	if status != fiber.StatusOK {
+		t.Fatalf("Expected status OK for task creation, got: %v", status)
+	}
+	task := response["task"].(map[string]interface{})
+	if task["overlap"] != overlapSkip {
+		t.Errorf("Expected default overlap policy %s, got: %v", overlapSkip, task["overlap"])
+	}
+	taskID := int(task["task_id"].(float64))
+	defer deleteTask(app, t, taskID)
+
+	loaded, err := scanTask(db.QueryRow("SELECT "+taskColumns+" FROM tasks WHERE id = ?", taskID))
+	if err != nil {
+		t.Fatalf("Error loading task: %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		executeTask(loaded)
+		close(done)
+	}()
+	for inflightCount(taskID) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The same occurrence is not fired twice, the next one is skipped
+	executeTask(loaded)
+	next := loaded
+	next.Start++
+	executeTask(next)
+	<-done
+
+	var statuses []string
+	rows, err := db.Query("SELECT status FROM task_runs WHERE task_id = ? ORDER BY id", taskID)
+	if err != nil {
+		t.Fatalf("Error loading runs: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s string
+		rows.Scan(&s)
+		statuses = append(statuses, s)
+	}
+	if len(statuses) != 2 || statuses[0] != runStatusSkipped || statuses[1] != runStatusSuccess {
+		t.Errorf("Expected a skipped and a successful run, got: %v", statuses)
+	}
+}
+
+func TestWorkerPoolLimits(t *testing.T) {
+	// One worker, one queue slot and two executions per user. Executions
+	// block until released rather than touching the database.
+	release := make(chan struct{})
+	p := newWorkerPool(1, 1, 2, func(Task) { <-release })
+	start := time.Now().Unix() + 3600
+	task := func(id, userID int) Task {
+		return Task{ID: id, UserID: userID, Start: start}
+	}
+
+	if err := p.submit(task(-1, -1)); err != nil {
+		t.Fatalf("Expected first task to be accepted, got: %v", err)
+	}
+	for p.stats().Running == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := p.submit(task(-1, -1)); err != nil {
+		t.Errorf("Expected duplicate occurrence to be ignored, got: %v", err)
+	}
+	if err := p.submit(task(-2, -1)); err != nil {
+		t.Errorf("Expected second task to be queued, got: %v", err)
+	}
+	if err := p.submit(task(-3, -1)); err != errUserLimit {
+		t.Errorf("Expected user limit rejection, got: %v", err)
+	}
+	if err := p.submit(task(-4, -2)); err != errQueueFull {
+		t.Errorf("Expected queue full rejection, got: %v", err)
+	}
+
+	stats := p.stats()
+	if stats.QueueDepth != 1 || stats.RejectedUserLimit != 1 || stats.RejectedQueueFull != 1 {
+		t.Errorf("Unexpected pool stats: %+v", stats)
+	}
+	close(release)
+}
+
+func TestHealth(t *testing.T) {
+	app := setupRouter()
+
+	if status, body := apiRequest(app, t, "GET", "/healthz", "", nil); status != fiber.StatusOK || body["status"] != "ok" {
+		t.Errorf("Expected the process to be alive, got: %v %v", status, body)
+	}
+
+	// Wait for the scheduler started by setupRouter to beat
+	for deadline := time.Now().Add(2 * time.Second); schedulerHeartbeat.Load() == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	status, body := apiRequest(app, t, "GET", "/readyz", "", nil)
+	if status != fiber.StatusOK || body["status"] != "ok" {
+		t.Fatalf("Expected the server to be ready, got: %v %v", status, body)
+	}
+	for _, name := range []string{"database", "schema", "scheduler", "worker_queue"} {
+		check, _ := body["checks"].(map[string]interface{})[name].(map[string]interface{})
+		if check["status"] != "ok" {
+			t.Errorf("Expected the %s check to pass, got: %v", name, check)
+		}
+	}
+
+	// A wedged scheduler makes the server unready
+	beat(time.Now().Add(-2 * heartbeatTimeout))
+	defer beat(time.Now())
+	status, body = apiRequest(app, t, "GET", "/readyz", "", nil)
+	scheduler, _ := body["checks"].(map[string]interface{})["scheduler"].(map[string]interface{})
+	if status != fiber.StatusServiceUnavailable || scheduler["status"] != "failing" {
+		t.Errorf("Expected a stale heartbeat to fail readiness, got: %v %v", status, body)
+	}
+}
+
+func TestShutdownDrain(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done() // Never answers on its own
+	}))
+	defer server.Close()
+
+	p := newWorkerPool(1, 1, 0, executeTask)
+	start := time.Now().Unix() + 3600
+	task := func(id int) Task {
+		return Task{ID: id, UserID: -1, URL: server.URL, Start: start, Timeout: 60}
+	}
+	var before int
+	db.QueryRow("SELECT COUNT(*) FROM task_runs WHERE task_id = -22").Scan(&before)
+
+	if err := p.submit(task(-21)); err != nil {
+		t.Fatalf("Expected task to be accepted, got: %v", err)
+	}
+	for p.stats().Running == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := p.submit(task(-22)); err != nil {
+		t.Fatalf("Expected task to be queued, got: %v", err)
+	}
+
+	// A closed pool takes no new work and drops what is queued
+	p.close()
+	if err := p.submit(task(-23)); err != errPoolClosed {
+		t.Errorf("Expected closed pool rejection, got: %v", err)
+	}
+
+	// Executions still running at the deadline are cancelled and recorded
+	deadline, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if n := waitForExecutions(deadline); n != 1 {
+		t.Errorf("Expected 1 execution still running at the deadline, got: %d", n)
+	}
+	cancelExecutions()
+	grace, cancelGrace := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelGrace()
+	if n := waitForExecutions(grace); n != 0 {
+		t.Errorf("Expected executions to stop after cancellation, got: %d", n)
+	}
+
+	var status string
+	db.QueryRow("SELECT status FROM task_runs WHERE task_id = -21 ORDER BY id DESC LIMIT 1").Scan(&status)
+	if status != runStatusCancelled {
+		t.Errorf("Expected the interrupted run to be recorded as cancelled, got: %q", status)
+	}
+	for p.stats().QueueDepth > 0 || p.stats().Running > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	var after int
+	db.QueryRow("SELECT COUNT(*) FROM task_runs WHERE task_id = -22").Scan(&after)
+	if after != before {
+		t.Errorf("Expected the queued task to be dropped, got %d new runs", after-before)
+	}
+}
+
+func TestCancelledOneShotTaskKept(t *testing.T) {
+	app := newTestApp()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done() // Never answers on its own
+	}))
+	defer server.Close()
+
+	status, response := postSchedule(app, t, map[string]interface{}{
+		"name":    randomTaskName(nil),
+		"url":     server.URL,
+		"start":   time.Now().Unix() + 3600,
+		"timeout": 60,
+	})
+	if status != fiber.StatusOK {
+		t.Fatalf("Expected status OK for task creation, got: %v %v", status, response)
+	}
+	taskID := int(response["task"].(map[string]interface{})["task_id"].(float64))
+	defer deleteTask(app, t, taskID)
+	task, err := scanTask(db.QueryRow("SELECT "+taskColumns+" FROM tasks WHERE id = ?", taskID))
+	if err != nil {
+		t.Fatalf("Error loading task: %v", err)
+	}
+
+	// The shutdown deadline cancels the execution before the webhook answers
+	done := make(chan struct{})
+	go func() {
+		executeTask(task)
+		close(done)
+	}()
+	for inflightCount(taskID) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancelExecutions()
+	<-done
+
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM tasks WHERE id = ?", taskID).Scan(&count)
+	if count != 1 {
+		t.Error("Expected the cancelled one-shot task to be kept for the next start")
+	}
+}
+
+func TestTaskQueue(t *testing.T) {
+	q := newTaskQueue()
+	q.upsert(-1, 300)
+	q.upsert(-2, 100)
+	q.upsert(-3, 200)
+	q.upsert(-1, 50) // Moving a task reorders the queue
+	q.remove(-3)
+
+	if start, ok := q.next(); !ok || start != 50 {
+		t.Errorf("Expected earliest start 50, got: %d", start)
+	}
+	if _, ok := q.popDue(10); ok {
+		t.Error("Expected no task due before its start")
+	}
+	var order []int
+	for {
+		taskID, ok := q.popDue(1000)
+		if !ok {
+			break
+		}
+		order = append(order, taskID)
+	}
+	if len(order) != 2 || order[0] != -1 || order[1] != -2 {
+		t.Errorf("Expected tasks -1 then -2, got: %v", order)
+	}
+}
+
+func TestSchedulerInvalidation(t *testing.T) {
+	app := newTestApp()
+
+	queued := func(taskID int) bool {
+		scheduler.mu.Lock()
+		defer scheduler.mu.Unlock()
+		_, ok := scheduler.items[taskID]
+		return ok
+	}
+
+	now := time.Now().Unix()
+	status, response := postSchedule(app, t, map[string]interface{}{
+		"name":    randomTaskName(nil),
+		"url":     "http://example.com",
+		"start":   now + 3600,
+		"end":     now + 7200,
+		"enabled": true,
+	})
+	if status != fiber.StatusOK {
+		t.Fatalf("Expected status OK for task creation, got: %v", status)
+	}
+	taskID := int(response["task"].(map[string]interface{})["task_id"].(float64))
+	if !queued(taskID) {
+		t.Error("Expected created task to be queued")
+	}
+
+	setTaskEnabled(app, t, taskID, false)
+	if queued(taskID) {
+		t.Error("Expected disabled task to leave the queue")
+	}
+	setTaskEnabled(app, t, taskID, true)
+	if !queued(taskID) {
+		t.Error("Expected enabled task to be queued again")
+	}
+	deleteTask(app, t, taskID)
+	if queued(taskID) {
+		t.Error("Expected deleted task to leave the queue")
+	}
+
+	// Tasks without an end never expire, and tasks that already ended are refused
+	status, response = postSchedule(app, t, map[string]interface{}{
+		"name":    randomTaskName(nil),
+		"url":     "http://example.com",
+		"start":   now + 3600,
+		"enabled": true,
+	})
+	if status != fiber.StatusOK {
+		t.Fatalf("Expected status OK for an open-ended task, got: %v %v", status, response)
+	}
+	taskID = int(response["task"].(map[string]interface{})["task_id"].(float64))
+	defer deleteTask(app, t, taskID)
+	if !queued(taskID) {
+		t.Error("Expected a task without end to be queued")
+	}
+	scheduler.load()
+	if !queued(taskID) {
+		t.Error("Expected a task without end to be queued after a reload")
+	}
+	status, response = postSchedule(app, t, map[string]interface{}{
+		"name":  randomTaskName(nil),
+		"url":   "http://example.com",
+		"start": now - 7200,
+		"end":   now - 3600,
+	})
+	if status != fiber.StatusUnprocessableEntity {
+		t.Errorf("Expected status Unprocessable Entity for a task that already ended, got: %v %v", status, response)
+	}
+}
+
+func TestUpdateTask(t *testing.T) {
+	app := newTestApp()
+	admin := "Bearer " + defaultToken
+
+	now := time.Now().Unix()
+	status, response := postSchedule(app, t, map[string]interface{}{
+		"name":    randomTaskName(nil),
+		"url":     "http://example.com",
+		"start":   now + 3600,
+		"end":     now + 7200,
+		"headers": map[string]string{"X-Old": "1"},
+	})
+	if status != fiber.StatusOK {
+		t.Fatalf("Expected status OK for task creation, got: %v", status)
+	}
+	taskID := int(response["task"].(map[string]interface{})["task_id"].(float64))
+	path := "/api/tasks/" + strconv.Itoa(taskID)
+
+	// Only the given fields change, and the new schedule is queued at once
+	status, updated := apiRequest(app, t, "PATCH", path, admin, map[string]interface{}{
+		"url":      "http://example.org/hook",
+		"interval": 300,
+		"start":    now + 1800,
+		"enabled":  true,
+		"headers":  map[string]string{"X-New": "2"},
+	})
+	if status != fiber.StatusOK {
+		t.Fatalf("Expected status OK for task update, got: %v %v", status, updated)
+	}
+	task, err := scanTask(db.QueryRow("SELECT "+taskColumns+" FROM tasks WHERE id = ?", taskID))
+	if err != nil {
+		t.Fatalf("Error loading task: %v", err)
+	}
+	if task.URL != "http://example.org/hook" || task.Interval != 300 || task.End != now+7200 || task.Method != "GET" {
+		t.Errorf("Expected a partial update, got: %+v", task)
+	}
+	if len(task.Headers) != 1 || task.Headers["X-New"] != "2" {
+		t.Errorf("Expected headers to be replaced, got: %v", task.Headers)
+	}
+	scheduler.mu.Lock()
+	item, ok := scheduler.items[taskID]
+	scheduler.mu.Unlock()
+	if !ok || item.start != now+1800 {
+		t.Errorf("Expected the updated start to be queued, got: %v %v", ok, item)
+	}
+
+	// Updates are validated and names stay unique per user
+	if status, _ := apiRequest(app, t, "PATCH", path, admin, map[string]interface{}{"timezone": "Mars/Olympus"}); status != fiber.StatusUnprocessableEntity {
+		t.Errorf("Expected status Unprocessable Entity for an invalid timezone, got: %v", status)
+	}
+	other := randomTaskName(nil)
+	_, response = postSchedule(app, t, map[string]interface{}{"name": other, "url": "http://example.com", "start": now + 3600})
+	otherID := int(response["task"].(map[string]interface{})["task_id"].(float64))
+	defer deleteTask(app, t, otherID)
+	if status, _ := apiRequest(app, t, "PATCH", path, admin, map[string]interface{}{"name": other}); status != fiber.StatusConflict {
+		t.Errorf("Expected status Conflict for a duplicate name, got: %v", status)
+	}
+	if status, _ := apiRequest(app, t, "PATCH", "/api/tasks/0", admin, map[string]interface{}{"url": "http://example.com"}); status != fiber.StatusNotFound {
+		t.Errorf("Expected status Not Found for a missing task, got: %v", status)
+	}
+	deleteTask(app, t, taskID)
+}
+
+func TestAPIv1(t *testing.T) {
+	app := newTestApp()
+	admin := "Bearer " + defaultToken
+
+	expectError := func(status int, body map[string]interface{}, want int, code string) {
+		t.Helper()
+		envelope, _ := body["error"].(map[string]interface{})
+		if status != want || envelope["code"] != code || envelope["message"] == "" {
+			t.Errorf("Expected %d %s error envelope, got: %v %v", want, code, status, body)
+		}
+	}
+
+	now := time.Now().Unix()
+	status, created := apiRequest(app, t, "POST", "/api/v1/tasks", admin, map[string]interface{}{
+		"name":    randomTaskName(nil),
+		"url":     "http://example.com",
+		"start":   now + 3600,
+		"end":     now + 7200,
+		"enabled": true,
+	})
+	if status != fiber.StatusCreated {
+		t.Fatalf("Expected status Created, got: %v %v", status, created)
+	}
+	taskID := int(created["task"].(map[string]interface{})["task_id"].(float64))
+	path := "/api/v1/tasks/" + strconv.Itoa(taskID)
 
-	// Teardown code can go here, such as closing database connections
+	if status, body := apiRequest(app, t, "GET", path, admin, nil); status != fiber.StatusOK || int(body["task"].(map[string]interface{})["id"].(float64)) != taskID {
+		t.Errorf("Expected the task, got: %v %v", status, body)
+	}
+	if status, body := apiRequest(app, t, "POST", path+":disable", admin, nil); status != fiber.StatusOK || body["task"].(map[string]interface{})["enabled"] != false {
+		t.Errorf("Expected the task to be disabled, got: %v %v", status, body)
+	}
+	if status, _ := apiRequest(app, t, "GET", "/api/v1/tasks", admin, nil); status != fiber.StatusOK {
+		t.Errorf("Expected status OK listing tasks, got: %v", status)
+	}
+
+	// Errors share one envelope
+	status, body := apiRequest(app, t, "GET", path, "", nil)
+	expectError(status, body, fiber.StatusUnauthorized, "unauthorized")
+	status, body = apiRequest(app, t, "POST", "/api/v1/tasks", admin, "not a task")
+	expectError(status, body, fiber.StatusBadRequest, "invalid_request")
+	status, body = apiRequest(app, t, "POST", "/api/v1/tasks", admin, map[string]interface{}{"name": randomTaskName(nil), "timezone": "Mars/Olympus"})
+	expectError(status, body, fiber.StatusUnprocessableEntity, "validation_failed")
+	status, body = apiRequest(app, t, "GET", "/api/v1/unknown", admin, nil)
+	expectError(status, body, fiber.StatusNotFound, "not_found")
+
+	if status, _ := apiRequest(app, t, "DELETE", path, admin, nil); status != fiber.StatusNoContent {
+		t.Errorf("Expected status No Content deleting the task, got: %v", status)
+	}
+	status, body = apiRequest(app, t, "GET", path, admin, nil)
+	expectError(status, body, fiber.StatusNotFound, "not_found")
+}
+
+func TestTaskValidation(t *testing.T) {
+	app := newTestApp()
+	admin := "Bearer " + defaultToken
+
+	now := time.Now().Unix()
+	status, body := apiRequest(app, t, "POST", "/api/v1/tasks", admin, map[string]interface{}{
+		"name":         "",
+		"url":          "ftp://example.com/file",
+		"interval":     0,
+		"start":        now + 3600,
+		"end":          now,
+		"is_recurring": true,
+		"timezone":     "Mars/Olympus",
+		"retry":        map[string]interface{}{"max_attempts": 20},
+		"overlap":      "sometimes",
+		"body":         `{"x": {{.Nope}}}`,
+	})
+	envelope, _ := body["error"].(map[string]interface{})
+	if status != fiber.StatusUnprocessableEntity || envelope["code"] != "validation_failed" {
+		t.Fatalf("Expected status Unprocessable Entity, got: %v %v", status, body)
+	}
+	fields := map[string]bool{}
+	for _, e := range envelope["fields"].([]interface{}) {
+		fields[e.(map[string]interface{})["field"].(string)] = true
+	}
+	for _, field := range []string{"name", "url", "interval", "end", "timezone", "retry", "overlap", "body"} {
+		if !fields[field] {
+			t.Errorf("Expected a field error for %s, got: %v", field, envelope["fields"])
+		}
+	}
+
+	// Names are limited in length and charset, URLs need a host
+	for _, task := range []map[string]interface{}{
+		{"name": strings.Repeat("a", maxNameLength+1), "url": "http://example.com"},
+		{"name": "bad\nname", "url": "http://example.com"},
+		{"name": randomTaskName(nil), "url": "http:///path"},
+		{"name": randomTaskName(nil), "url": "http://example.com", "interval": -1},
+	} {
+		if status, body := postSchedule(app, t, task); status != fiber.StatusUnprocessableEntity || body["fields"] == nil {
+			t.Errorf("Expected status Unprocessable Entity for %v, got: %v %v", task, status, body)
+		}
+	}
+}
+
+func TestManualRun(t *testing.T) {
+	app := newTestApp()
+	admin := "Bearer " + defaultToken
+
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	now := time.Now().Unix()
+	status, response := postSchedule(app, t, map[string]interface{}{
+		"name":         randomTaskName(nil),
+		"url":          server.URL,
+		"interval":     3600,
+		"start":        now + 3600,
+		"end":          now + 7200,
+		"is_recurring": true,
+		"enabled":      true,
+	})
+	if status != fiber.StatusOK {
+		t.Fatalf("Expected status OK for task creation, got: %v", status)
+	}
+	taskID := int(response["task"].(map[string]interface{})["task_id"].(float64))
+	defer deleteTask(app, t, taskID)
+	path := "/api/v1/tasks/" + strconv.Itoa(taskID) + ":run"
+
+	// A synchronous run returns its result and leaves the schedule alone
+	status, body := apiRequest(app, t, "POST", path, admin, nil)
+	if status != fiber.StatusOK || body["status"] != runStatusSuccess {
+		t.Fatalf("Expected a successful manual run, got: %v %v", status, body)
+	}
+	if run := body["runs"].([]interface{})[0].(map[string]interface{}); run["trigger"] != runTriggerManual {
+		t.Errorf("Expected the run to be recorded as manual, got: %v", run)
+	}
+	var start int64
+	db.QueryRow("SELECT start FROM tasks WHERE id = ?", taskID).Scan(&start)
+	if start != now+3600 {
+		t.Errorf("Expected start to stay %d, got: %d", now+3600, start)
+	}
+
+	// An asynchronous run returns a run ID that completes later
+	status, body = apiRequest(app, t, "POST", path+"?async=true", admin, nil)
+	if status != fiber.StatusAccepted {
+		t.Fatalf("Expected status Accepted, got: %v %v", status, body)
+	}
+	runID := int(body["run_id"].(float64))
+	var runStatus, trigger string
+	for i := 0; i < 50; i++ {
+		db.QueryRow("SELECT status, trigger FROM task_runs WHERE id = ?", runID).Scan(&runStatus, &trigger)
+		if runStatus != runStatusRunning {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if runStatus != runStatusSuccess || trigger != runTriggerManual {
+		t.Errorf("Expected the async run to succeed as manual, got: %s %s", runStatus, trigger)
+	}
+	if n := atomic.LoadInt32(&hits); n != 2 {
+		t.Errorf("Expected 2 requests, got: %d", n)
+	}
+
+	// Synchronous runs make a single attempt
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	status, response = postSchedule(app, t, map[string]interface{}{
+		"name":  randomTaskName(nil),
+		"url":   failing.URL,
+		"start": now + 3600,
+		"retry": map[string]interface{}{"max_attempts": 3, "initial_delay_ms": 1},
+	})
+	if status != fiber.StatusOK {
+		t.Fatalf("Expected status OK for task creation, got: %v %v", status, response)
+	}
+	otherID := int(response["task"].(map[string]interface{})["task_id"].(float64))
+	defer deleteTask(app, t, otherID)
+	other := "/api/v1/tasks/" + strconv.Itoa(otherID) + ":run"
+	if status, body := apiRequest(app, t, "POST", other, admin, nil); status != fiber.StatusOK || len(body["runs"].([]interface{})) != 1 {
+		t.Errorf("Expected a single failed attempt, got: %v %v", status, body)
+	}
+
+	// Runs wait in the worker pool like scheduled executions, within its
+	// per-user limit, and a task has one manual run at a time
+	release := make(chan struct{})
+	saved := pool
+	pool = newWorkerPool(1, 10, 2, func(Task) { <-release })
+	defer func() { pool = saved }()
+	user, _ := authenticateToken(defaultToken)
+	pool.submit(Task{ID: -1, UserID: user.ID})
+	status, body = apiRequest(app, t, "POST", path+"?async=true", admin, nil)
+	if status != fiber.StatusAccepted {
+		t.Fatalf("Expected status Accepted, got: %v %v", status, body)
+	}
+	runID = int(body["run_id"].(float64))
+	if status, _ := apiRequest(app, t, "POST", path, admin, nil); status != fiber.StatusConflict {
+		t.Errorf("Expected status Conflict for a second manual run, got: %v", status)
+	}
+	if status, _ := apiRequest(app, t, "POST", other+"?async=true", admin, nil); status != fiber.StatusTooManyRequests {
+		t.Errorf("Expected status Too Many Requests over the user limit, got: %v", status)
+	}
+	close(release)
+	for i := 0; i < 50; i++ {
+		db.QueryRow("SELECT status FROM task_runs WHERE id = ?", runID).Scan(&runStatus)
+		if runStatus != runStatusRunning {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if runStatus != runStatusSuccess {
+		t.Errorf("Expected the queued run to succeed once a worker is free, got: %s", runStatus)
+	}
+}
+
+func TestTaskListQuery(t *testing.T) {
+	app := newTestApp()
+
+	// A fresh user keeps the listing independent of other tests
+	_, registered := apiRequest(app, t, "POST", "/register", "", map[string]string{"username": randomTaskName(nil)})
+	user := "Bearer " + registered["token"].(string)
+
+	now := time.Now().Unix()
+	for i, url := range []string{
+		"http://alpha.example.com/hook",
+		"https://alpha.example.com:8443",
+		"http://alpha.example.com.evil.net/",
+		"http://beta.example.com?x=1",
+		"http://beta.example.com/hook",
+	} {
+		status, body := apiRequest(app, t, "POST", "/api/v1/tasks", user, map[string]interface{}{
+			"name":    fmt.Sprintf("List %c task", 'A'+i),
+			"url":     url,
+			"start":   now + int64(1000*(5-i)),
+			"end":     now + 7200,
+			"enabled": i%2 == 0,
+		})
+		if status != fiber.StatusCreated {
+			t.Fatalf("Expected status Created, got: %v %v", status, body)
+		}
+	}
+
+	list := func(query string) ([]string, map[string]interface{}) {
+		t.Helper()
+		status, body := apiRequest(app, t, "GET", "/api/v1/tasks?"+query, user, nil)
+		if status != fiber.StatusOK {
+			t.Fatalf("Expected status OK for %q, got: %v %v", query, status, body)
+		}
+		var names []string
+		for _, task := range body["tasks"].([]interface{}) {
+			names = append(names, task.(map[string]interface{})["name"].(string))
+		}
+		return names, body
+	}
+
+	// Cursor pages cover every task once
+	var all []string
+	query := "limit=2"
+	for {
+		names, body := list(query)
+		all = append(all, names...)
+		if body["total"].(float64) != 5 {
+			t.Errorf("Expected a total of 5, got: %v", body["total"])
+		}
+		if body["next_cursor"] == "" {
+			break
+		}
+		query = "limit=2&cursor=" + body["next_cursor"].(string)
+	}
+	if strings.Join(all, ",") != "List A task,List B task,List C task,List D task,List E task" {
+		t.Errorf("Expected every task once in order, got: %v", all)
+	}
+
+	for query, want := range map[string]string{
+		"enabled=true":                              "List A task,List C task,List E task",
+		"name_prefix=List%20B":                      "List B task",
+		"url_host=alpha.example.com":                "List A task,List B task",
+		"url_host=beta.example.com&recurring=false": "List D task,List E task",
+		"sort=-name&limit=2":                        "List E task,List D task",
+		"sort=next_run&next_run_after=" + strconv.FormatInt(now+2500, 10): "List C task,List B task,List A task",
+	} {
+		if names, _ := list(query); strings.Join(names, ",") != want {
+			t.Errorf("Expected %s for %q, got: %v", want, query, names)
+		}
+	}
+	_, body := list("sort=-next_run&limit=1")
+	names, _ := list("sort=-next_run&limit=1&cursor=" + body["next_cursor"].(string))
+	if len(names) != 1 || names[0] != "List B task" {
+		t.Errorf("Expected the second page sorted by next run, got: %v", names)
+	}
+
+	if status, _ := apiRequest(app, t, "GET", "/api/v1/tasks?sort=url", user, nil); status != fiber.StatusBadRequest {
+		t.Errorf("Expected status Bad Request for an unknown sort, got: %v", status)
+	}
+	if status, _ := apiRequest(app, t, "GET", "/api/v1/tasks?cursor=abc&sort=name", user, nil); status != fiber.StatusBadRequest {
+		t.Errorf("Expected status Bad Request for an invalid cursor, got: %v", status)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	app := newTestApp()
+	admin := "Bearer " + defaultToken
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	now := time.Now().Unix()
+	_, created := apiRequest(app, t, "POST", "/api/v1/tasks", admin, map[string]interface{}{
+		"name":  randomTaskName(nil),
+		"url":   server.URL,
+		"start": now + 3600,
+		"end":   now + 7200,
+	})
+	taskID := int(created["task"].(map[string]interface{})["task_id"].(float64))
+	defer deleteTask(app, t, taskID)
+	if status, body := apiRequest(app, t, "POST", "/api/v1/tasks/"+strconv.Itoa(taskID)+":run", admin, nil); status != fiber.StatusOK {
+		t.Fatalf("Expected status OK for a manual run, got: %v %v", status, body)
+	}
+	apiRequest(app, t, "GET", "/api/v1/tasks/0", admin, nil)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/metrics", nil))
+	if err != nil {
+		t.Fatalf("Error making request to in-memory app: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	for _, metric := range []string{
+		`scheduler_task_executions_total{outcome="success",trigger="manual"}`,
+		`scheduler_task_execution_duration_seconds_count{outcome="success"}`,
+		`scheduler_inflight_executions 0`,
+		`scheduler_worker_rejections_total{reason="queue_full"}`,
+		`scheduler_worker_rejections_total{reason="user_limit"}`,
+		`http_request_duration_seconds_count{method="POST",route="/api/v1/tasks",status="201"}`,
+		`http_request_duration_seconds_count{method="GET",route="/api/v1/tasks/:id",status="404"}`,
+	} {
+		if !strings.Contains(string(body), metric) {
+			t.Errorf("Expected %s in the metrics", metric)
+		}
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	app := newTestApp()
+
+	status, document := apiRequest(app, t, "GET", "/openapi.json", "", nil)
+	if status != fiber.StatusOK || document["openapi"] != "3.0.3" {
+		t.Fatalf("Expected the OpenAPI document, got: %v %v", status, document["openapi"])
+	}
+	schemas := document["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	properties := schemas["Task"].(map[string]interface{})["properties"].(map[string]interface{})
+	if _, ok := properties["misfire_threshold"]; !ok {
+		t.Errorf("Expected the Task schema to be derived from Task, got: %v", properties)
+	}
+
+	// Every registered route is documented, and nothing else is
+	paths := document["paths"].(map[string]interface{})
+	documented := 0
+	for _, item := range paths {
+		documented += len(item.(map[string]interface{}))
+	}
+	registered := 0
+	for _, route := range app.GetRoutes(true) {
+		if route.Method == fiber.MethodHead {
+			continue
+		}
+		registered++
+		path, _ := openAPIPath(route.Path)
+		item, _ := paths[path].(map[string]interface{})
+		if _, ok := item[strings.ToLower(route.Method)]; !ok {
+			t.Errorf("Route %s %s is missing from the OpenAPI document", route.Method, route.Path)
+		}
+	}
+	// GetRoutes leaves out the web interface served by app.Static, so it is
+	// checked by requesting it
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	if err != nil || resp.StatusCode != fiber.StatusOK {
+		t.Errorf("Expected the web interface to be served, got: %v %v", resp, err)
+	}
+	registered++
+	if item, _ := paths["/"].(map[string]interface{}); item["get"] == nil {
+		t.Error("Route GET / is missing from the OpenAPI document")
+	}
+	if documented != registered {
+		t.Errorf("Expected %d documented operations, got: %d", registered, documented)
+	}
+
+	// Only legacy routes with a documented replacement are deprecated
+	for _, op := range apiOperations() {
+		for _, replacement := range op.replacedBy {
+			method, path, _ := strings.Cut(replacement, " ")
+			path, _ = openAPIPath(path)
+			item, _ := paths[path].(map[string]interface{})
+			if _, ok := item[strings.ToLower(method)]; !ok {
+				t.Errorf("Route %s %s is replaced by the undocumented %s", op.method, op.path, replacement)
+			}
+		}
+	}
+	create := paths["/api/v1/tasks"].(map[string]interface{})["post"].(map[string]interface{})
+	if _, ok := create["responses"].(map[string]interface{})["422"]; !ok {
+		t.Errorf("Expected task creation to document its validation errors, got: %v", create["responses"])
+	}
+}
+
+func TestMisfirePolicy(t *testing.T) {
+	now := time.Now()
+	// Down for an hour with a 10 minute interval: 7 occurrences were missed
+	task := Task{ID: -1, Interval: 600, IsRecurring: true, Start: now.Unix() - 3600, MisfireLimit: 3}
+
+	task.Misfire = misfireFireAll
+	starts, note := planOccurrences(task, now)
+	if len(starts) != 3 || starts[2] != task.Start+3600 || note == "" {
+		t.Errorf("Expected the last 3 missed occurrences, got: %v (%s)", starts, note)
+	}
+
+	task.Misfire = misfireFireOnce
+	if starts, _ := planOccurrences(task, now); len(starts) != 1 || starts[0] != task.Start+3600 {
+		t.Errorf("Expected a single run for the latest occurrence, got: %v", starts)
+	}
+
+	task.Misfire = misfireSkip
+	if starts, _ := planOccurrences(task, now); len(starts) != 0 {
+		t.Errorf("Expected no run when skipping, got: %v", starts)
+	}
+
+	// Cron schedules count their missed occurrences the same way
+	cronTask := Task{ID: -1, Schedule: "*/10 * * * *", IsRecurring: true, Start: now.Truncate(10*time.Minute).Unix() - 3600, MisfireLimit: 3}
+	if total, starts := missedStarts(cronTask, now, 3); total != 7 || len(starts) != 3 || starts[2] != cronTask.Start+3600 {
+		t.Errorf("Expected 7 missed cron occurrences ending with the latest, got: %d %v", total, starts)
+	}
+
+	// One-shot tasks have no next occurrence to skip to, so they run once
+	oneShot := Task{ID: -1, Start: now.Unix() - 3600, Misfire: misfireSkip}
+	if starts, _ := planOccurrences(oneShot, now); len(starts) != 1 || starts[0] != oneShot.Start {
+		t.Errorf("Expected a late one-shot task to run once when skipping, got: %v", starts)
+	}
+
+	// Runs within the threshold are not misfires
+	task.Start = now.Unix() - 5
+	if starts, note := planOccurrences(task, now); len(starts) != 1 || note != "" {
+		t.Errorf("Expected an on-time run, got: %v (%s)", starts, note)
+	}
+}
+
+func TestBearerAuth(t *testing.T) {
+	app := newTestApp()
+
+	// Registration returns a token that is only stored hashed
+	username := randomTaskName(nil)
+	body, _ := json.Marshal(map[string]string{"username": username})
+	req := httptest.NewRequest("POST", "/register", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Error making request to in-memory app: %v", err)
+	}
+	var registered map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&registered); err != nil {
+		t.Fatalf("Error parsing response: %v", err)
+	}
+	token := registered["token"]
+
+	var stored, hash string
+	if err := db.QueryRow("SELECT token, token_hash FROM users WHERE username = ?", username).Scan(&stored, &hash); err != nil {
+		t.Fatalf("Error loading user: %v", err)
+	}
+	if stored != "" || hash == "" || hash == token {
+		t.Errorf("Expected only a token hash to be stored, got token %q and hash %q", stored, hash)
+	}
+
+	fetch := func(authorization string, body string) int {
+		req := httptest.NewRequest("POST", "/api/tasks", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Error making request to in-memory app: %v", err)
+		}
+		return resp.StatusCode
+	}
+
+	if status := fetch("Bearer "+token, ""); status != fiber.StatusOK {
+		t.Errorf("Expected status OK with bearer token, got: %v", status)
+	}
+	if status := fetch("Bearer wrong-token", ""); status != fiber.StatusUnauthorized {
+		t.Errorf("Expected status Unauthorized with a wrong token, got: %v", status)
+	}
+
+	// Body credentials are only accepted behind the deprecation flag
+	creds := `{"username": "` + username + `", "token": "` + token + `"}`
+	if status := fetch("", creds); status != fiber.StatusUnauthorized {
+		t.Errorf("Expected status Unauthorized for body credentials, got: %v", status)
+	}
+	allowBodyCredentials = true
+	defer func() { allowBodyCredentials = false }()
+	if status := fetch("", creds); status != fiber.StatusOK {
+		t.Errorf("Expected status OK for body credentials behind the flag, got: %v", status)
+	}
+}
+
+// apiRequest sends a JSON request with the given Authorization header and
+// returns the status and decoded response.
+func apiRequest(app *fiber.App, t *testing.T, method, path, authorization string, body interface{}) (int, map[string]interface{}) {
+	payload, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, path, bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", authorization)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Error making request to in-memory app: %v", err)
+	}
+	var result map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&result)
+	return resp.StatusCode, result
+}
+
+func TestAPIKeys(t *testing.T) {
+	app := newTestApp()
+
+	do := func(method, path, authorization string, body interface{}) (int, map[string]interface{}) {
+		return apiRequest(app, t, method, path, authorization, body)
+	}
+	admin := "Bearer " + defaultToken
+
+	// A read-only key can list tasks but not schedule them
+	name := randomTaskName(nil)
+	status, created := do("POST", "/api/keys", admin, map[string]interface{}{"name": name, "scopes": []string{"tasks:read"}})
+	if status != fiber.StatusOK {
+		t.Fatalf("Expected status OK creating a key, got: %v %v", status, created)
+	}
+	key := "Bearer " + created["key"].(string)
+	keyID := int(created["api_key"].(map[string]interface{})["id"].(float64))
+
+	if status, _ := do("POST", "/api/tasks", key, nil); status != fiber.StatusOK {
+		t.Errorf("Expected status OK listing tasks with a read key, got: %v", status)
+	}
+	if status, _ := do("POST", "/schedule", key, map[string]interface{}{"name": randomTaskName(nil)}); status != fiber.StatusForbidden {
+		t.Errorf("Expected status Forbidden scheduling with a read key, got: %v", status)
+	}
+	if status, _ := do("GET", "/api/keys", key, nil); status != fiber.StatusForbidden {
+		t.Errorf("Expected status Forbidden listing keys without the admin scope, got: %v", status)
+	}
+	if status, _ := do("POST", "/api/keys", admin, map[string]interface{}{"name": name, "scopes": []string{"tasks:read"}}); status != fiber.StatusConflict {
+		t.Errorf("Expected status Conflict for a duplicate key name, got: %v", status)
+	}
+	if status, _ := do("POST", "/api/keys", admin, map[string]interface{}{"name": randomTaskName(nil), "scopes": []string{"everything"}}); status != fiber.StatusBadRequest {
+		t.Errorf("Expected status Bad Request for an unknown scope, got: %v", status)
+	}
+
+	// Use is recorded
+	_, listed := do("GET", "/api/keys", admin, nil)
+	found := false
+	for _, k := range listed["keys"].([]interface{}) {
+		k := k.(map[string]interface{})
+		if int(k["id"].(float64)) == keyID {
+			found = true
+			if k["last_used_at"].(float64) == 0 {
+				t.Errorf("Expected last_used_at to be set, got: %v", k)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("Expected key %d in the key list", keyID)
+	}
+
+	// Revoked and expired keys are refused
+	if status, _ := do("DELETE", "/api/keys/"+strconv.Itoa(keyID), admin, nil); status != fiber.StatusOK {
+		t.Errorf("Expected status OK revoking a key, got: %v", status)
+	}
+	if status, _ := do("POST", "/api/tasks", key, nil); status != fiber.StatusUnauthorized {
+		t.Errorf("Expected status Unauthorized with a revoked key, got: %v", status)
+	}
+
+	_, created = do("POST", "/api/keys", admin, map[string]interface{}{"name": randomTaskName(nil), "scopes": []string{"tasks:read"}, "expires_at": time.Now().Unix() + 1})
+	key = "Bearer " + created["key"].(string)
+	time.Sleep(1100 * time.Millisecond)
+	if status, _ := do("POST", "/api/tasks", key, nil); status != fiber.StatusUnauthorized {
+		t.Errorf("Expected status Unauthorized with an expired key, got: %v", status)
+	}
+	// User tokens that happen to start with the key prefix still work
+	token := apiKeyPrefix + randomTaskName(nil)
+	if _, err := storeUser(randomTaskName(nil), token); err != nil {
+		t.Fatalf("Error storing user: %v", err)
+	}
+	if status, _ := do("POST", "/schedule", "Bearer "+token, map[string]interface{}{"name": ""}); status != fiber.StatusUnprocessableEntity {
+		t.Errorf("Expected a user token with the key prefix to authenticate with every scope, got: %v", status)
+	}
+}
+
+func TestTeams(t *testing.T) {
+	app := newTestApp()
+
+	do := func(method, path, authorization string, body interface{}) (int, map[string]interface{}) {
+		return apiRequest(app, t, method, path, authorization, body)
+	}
+	register := func() (string, string) {
+		username := randomTaskName(nil)
+		_, registered := do("POST", "/register", "", map[string]string{"username": username})
+		return username, "Bearer " + registered["token"].(string)
+	}
+	owner := "Bearer " + defaultToken
+	member, memberAuth := register()
+	_, outsiderAuth := register()
+
+	status, created := do("POST", "/api/teams", owner, map[string]string{"name": randomTaskName(nil)})
+	if status != fiber.StatusOK {
+		t.Fatalf("Expected status OK creating a team, got: %v %v", status, created)
+	}
+	teamID := int(created["team"].(map[string]interface{})["id"].(float64))
+	members := "/api/teams/" + strconv.Itoa(teamID) + "/members"
+	if status, _ := do("POST", members, owner, map[string]string{"username": member, "role": "viewer"}); status != fiber.StatusOK {
+		t.Fatalf("Expected status OK adding a viewer, got: %v", status)
+	}
+
+	teamTask := randomTaskName(nil)
+	status, scheduled := do("POST", "/schedule", owner, map[string]interface{}{
+		"name": teamTask, "url": "http://example.com", "interval": 60,
+		"start": time.Now().Unix() + 3600, "is_recurring": true, "team_id": teamID,
+	})
+	if status != fiber.StatusOK {
+		t.Fatalf("Expected status OK scheduling a team task, got: %v %v", status, scheduled)
+	}
+	taskID := int(scheduled["task"].(map[string]interface{})["task_id"].(float64))
+
+	// Viewers see team tasks but cannot change them
+	_, fetched := do("POST", "/api/tasks", memberAuth, nil)
+	found := false
+	for _, task := range fetched["tasks"].([]interface{}) {
+		found = found || int(task.(map[string]interface{})["id"].(float64)) == taskID
+	}
+	if !found {
+		t.Errorf("Expected team task %d in the viewer's tasks", taskID)
+	}
+	toggle := map[string]interface{}{"task_id": taskID, "enabled": false}
+	if status, _ := do("POST", "/api/tasks/set-enabled", memberAuth, toggle); status != fiber.StatusForbidden {
+		t.Errorf("Expected status Forbidden pausing as a viewer, got: %v", status)
+	}
+	if status, _ := do("POST", "/schedule", memberAuth, map[string]interface{}{"name": randomTaskName(nil), "team_id": teamID}); status != fiber.StatusForbidden {
+		t.Errorf("Expected status Forbidden scheduling as a viewer, got: %v", status)
+	}
+
+	// Editors can pause tasks created by someone else
+	if status, _ := do("POST", members, owner, map[string]string{"username": member, "role": "editor"}); status != fiber.StatusOK {
+		t.Fatalf("Expected status OK promoting to editor, got: %v", status)
+	}
+	if status, _ := do("POST", "/api/tasks/set-enabled", memberAuth, toggle); status != fiber.StatusOK {
+		t.Errorf("Expected status OK pausing as an editor, got: %v", status)
+	}
+
+	// Names are unique within the team, and apart from personal tasks
+	if status, _ := do("POST", "/schedule", memberAuth, map[string]interface{}{
+		"name": teamTask, "url": "http://example.com", "start": time.Now().Unix() + 3600, "team_id": teamID,
+	}); status != fiber.StatusConflict {
+		t.Errorf("Expected status Conflict reusing a team task name, got: %v", status)
+	}
+	for _, auth := range []string{owner, memberAuth} {
+		status, personal := do("POST", "/schedule", auth, map[string]interface{}{
+			"name": teamTask, "url": "http://example.com", "start": time.Now().Unix() + 3600,
+		})
+		if status != fiber.StatusOK {
+			t.Errorf("Expected status OK naming a personal task like a team task, got: %v %v", status, personal)
+			continue
+		}
+		do("DELETE", "/api/tasks/delete", auth, map[string]interface{}{"task_id": personal["task"].(map[string]interface{})["task_id"]})
+	}
+
+	// Outsiders cannot see the task, and the last owner cannot leave
+	if status, _ := do("DELETE", "/api/tasks/delete", outsiderAuth, map[string]int{"task_id": taskID}); status != fiber.StatusNotFound {
+		t.Errorf("Expected status Not Found deleting as an outsider, got: %v", status)
+	}
+	ownerUser, _ := authenticateToken(defaultToken)
+	if status, _ := do("DELETE", members+"/"+strconv.Itoa(ownerUser.ID), owner, nil); status != fiber.StatusConflict {
+		t.Errorf("Expected status Conflict removing the last owner, got: %v", status)
+	}
+	if status, _ := do("DELETE", "/api/tasks/delete", memberAuth, map[string]int{"task_id": taskID}); status != fiber.StatusOK {
+		t.Errorf("Expected status OK deleting as an editor, got: %v", status)
+	}
+
+	// A creator removed from the team loses the history of its tasks until
+	// the task is deleted
+	status, scheduled = do("POST", "/schedule", memberAuth, map[string]interface{}{
+		"name": randomTaskName(nil), "url": "http://example.com",
+		"start": time.Now().Unix() + 3600, "team_id": teamID,
+	})
+	if status != fiber.StatusOK {
+		t.Fatalf("Expected status OK scheduling as an editor, got: %v %v", status, scheduled)
+	}
+	taskID = int(scheduled["task"].(map[string]interface{})["task_id"].(float64))
+	memberUser, _ := authenticateToken(strings.TrimPrefix(memberAuth, "Bearer "))
+	recordRun(TaskRun{TaskID: taskID, UserID: memberUser.ID, StartedAt: time.Now().Unix(), Status: runStatusSuccess})
+	runs := "/api/tasks/" + strconv.Itoa(taskID) + "/runs"
+	if status, _ := do("DELETE", members+"/"+strconv.Itoa(memberUser.ID), owner, nil); status != fiber.StatusOK {
+		t.Fatalf("Expected status OK removing the member, got: %v", status)
+	}
+	if status, _ := do("GET", runs, memberAuth, nil); status != fiber.StatusNotFound {
+		t.Errorf("Expected status Not Found for the runs of a task no longer shared, got: %v", status)
+	}
+	if status, _ := do("DELETE", "/api/tasks/delete", owner, map[string]int{"task_id": taskID}); status != fiber.StatusOK {
+		t.Errorf("Expected status OK deleting as the owner, got: %v", status)
+	}
+	if status, body := do("GET", runs, memberAuth, nil); status != fiber.StatusOK || body["total"] != float64(1) {
+		t.Errorf("Expected the creator to see the runs of the deleted task, got: %v %v", status, body)
+	}
+}
+
+func TestBootstrapAdmin(t *testing.T) {
+	app := newTestApp()
+
+	// A development account may use the token the first release hardcoded,
+	// which is only revoked once when migrating
+	devUser := randomTaskName(nil)
+	dev := AuthConfig{DevMode: true, DevUsername: devUser, DevToken: "123"}
+	bootstrapUsers(dev)
+	if applied, err := migrateDatabase(context.Background(), db, false); err != nil || len(applied) != 0 {
+		t.Fatalf("Expected no migration on restart, got: %d %v", len(applied), err)
+	}
+	bootstrapUsers(dev)
+	if _, err := authenticateCredentials(devUser, "123"); err != nil {
+		t.Errorf("Expected the development account to keep its token, got: %v", err)
+	}
+
+	// The former hardcoded "a"/"123" credentials are gone
+	body, _ := json.Marshal(map[string]string{"username": "a", "token": "123"})
+	req := httptest.NewRequest("POST", "/login", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Error making request to in-memory app: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusUnauthorized {
+		t.Errorf("Expected status Unauthorized for a/123, got: %v", resp.StatusCode)
+	}
+
+	// Only one admin can be bootstrapped
+	username := randomTaskName(nil)
+	token, err := createAdmin(username, "")
+	if err != nil {
+		t.Fatalf("Error creating admin: %v", err)
+	}
+	if user, err := authenticateToken(token); err != nil || user.Username != username {
+		t.Errorf("Expected generated admin token to authenticate, got: %v", err)
+	}
+	if _, err := createAdmin(randomTaskName(nil), ""); err != errAdminExists {
+		t.Errorf("Expected a second admin to be refused, got: %v", err)
+	}
+
+	// The admin owns the tasks and teams of other users
+	admin := "Bearer " + token
+	taskID := createTask(app, t)
+	toggle := map[string]interface{}{"task_id": taskID, "enabled": false}
+	if status, _ := apiRequest(app, t, "POST", "/api/tasks/set-enabled", admin, toggle); status != fiber.StatusOK {
+		t.Errorf("Expected status OK pausing another user's task as admin, got: %v", status)
+	}
+	status, created := apiRequest(app, t, "POST", "/api/teams", "Bearer "+defaultToken, map[string]string{"name": randomTaskName(nil)})
+	if status != fiber.StatusOK {
+		t.Fatalf("Expected status OK creating a team, got: %v %v", status, created)
+	}
+	members := "/api/teams/" + strconv.Itoa(int(created["team"].(map[string]interface{})["id"].(float64))) + "/members"
+	if status, _ := apiRequest(app, t, "POST", members, admin, map[string]string{"username": devUser, "role": "viewer"}); status != fiber.StatusOK {
+		t.Errorf("Expected status OK adding a member as admin, got: %v", status)
+	}
+	if status, _ := apiRequest(app, t, "GET", "/api/teams/999999/members", admin, nil); status != fiber.StatusNotFound {
+		t.Errorf("Expected status Not Found for a missing team as admin, got: %v", status)
+	}
+	deleteTask(app, t, taskID)
+}
+
+func TestConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	file := "server:\n  port: 8080\nscheduler:\n  workers: 5\n  queue_size: 20\n  retry_delay: 2s\nauth:\n  admin_token: secret\n"
+	if err := os.WriteFile(path, []byte(file), 0600); err != nil {
+		t.Fatalf("Error writing config file: %v", err)
+	}
+
+	// Environment variables override the file and flags override both
+	t.Setenv("WORKER_POOL_SIZE", "7")
+	t.Setenv("SHUTDOWN_TIMEOUT", "45")
+	config, printOnly, err := loadConfig([]string{"-config", path, "-scheduler.queue_size=9", "-auth.dev_mode", "-print-config"})
+	if err != nil {
+		t.Fatalf("Expected a valid configuration, got: %v", err)
+	}
+	if !printOnly || config.Server.Port != 8080 || config.Scheduler.Workers != 7 || config.Scheduler.QueueSize != 9 ||
+		config.Scheduler.RetryDelay != 2*time.Second || config.Server.ShutdownTimeout != 45*time.Second ||
+		!config.Auth.DevMode || config.Database.Path != defaultConfig().Database.Path {
+		t.Errorf("Unexpected configuration: %+v", config)
+	}
+
+	var printed strings.Builder
+	if err := printConfig(&printed, config); err != nil {
+		t.Fatalf("Error printing configuration: %v", err)
+	}
+	if strings.Contains(printed.String(), "secret") || !strings.Contains(printed.String(), "workers: 7") {
+		t.Errorf("Expected the printed configuration to redact tokens, got:\n%s", printed.String())
+	}
+
+	// Invalid values and unknown keys are rejected
+	for _, args := range [][]string{
+		{"-server.port=0"},
+		{"-log.format=xml"},
+		{"-http_client.default_timeout=10m"},
+		{"-scheduler.workers=many"},
+	} {
+		if _, _, err := loadConfig(args); err == nil {
+			t.Errorf("Expected %v to be rejected", args)
+		}
+	}
+	os.WriteFile(path, []byte("server:\n  prot: 8080\n"), 0600)
+	if _, _, err := loadConfig([]string{"-config", path}); err == nil {
+		t.Error("Expected an unknown key in the config file to be rejected")
+	}
+}
+
+// openFixture creates a database from a SQL fixture in testdata
+func openFixture(t *testing.T, fixture string, extra ...string) *sql.DB {
+	script, err := os.ReadFile(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatalf("Error reading fixture: %v", err)
+	}
+	conn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "tasks.db"))
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	for _, statement := range append([]string{string(script)}, extra...) {
+		if _, err := conn.Exec(statement); err != nil {
+			t.Fatalf("Error loading fixture: %v", err)
+		}
+	}
+	return conn
+}
+
+func TestMigrations(t *testing.T) {
+	ctx := context.Background()
+	conn := openFixture(t, "schema_v0.sql")
+	latest := latestSchemaVersion()
+
+	// A dry run lists every migration and leaves the database untouched
+	pending, err := migrateDatabase(ctx, conn, true)
+	if err != nil || len(pending) != latest {
+		t.Fatalf("Expected %d pending migrations, got: %d %v", latest, len(pending), err)
+	}
+	if _, err := conn.Exec("SELECT schedule FROM tasks"); err == nil {
+		t.Error("Expected the dry run to leave the tasks table unchanged")
+	}
+
+	applied, err := migrateDatabase(ctx, conn, false)
+	if err != nil || len(applied) != latest {
+		t.Fatalf("Expected %d applied migrations, got: %d %v", latest, len(applied), err)
+	}
+	if version, err := schemaVersion(ctx, conn); err != nil || version != latest {
+		t.Errorf("Expected schema version %d, got: %d %v", latest, version, err)
+	}
+
+	// Existing rows get the defaults of the new columns
+	task, err := scanTask(conn.QueryRow("SELECT " + taskColumns + " FROM tasks WHERE id = 1"))
+	if err != nil {
+		t.Fatalf("Error reading migrated task: %v", err)
+	}
+	if task.Name != "Legacy task" || task.Method != "GET" || task.Timezone != "UTC" || task.Overlap != "skip" || task.TeamID != 0 {
+		t.Errorf("Unexpected migrated task: %+v", task)
+	}
+	for _, query := range []string{
+		"SELECT " + runColumns + " FROM task_runs",
+		"SELECT " + apiKeyColumns + " FROM api_keys",
+		"SELECT id, username, token_prefix, token_hash, is_admin FROM users",
+		"SELECT team_id, user_id, role FROM team_members",
+	} {
+		if _, err := conn.Exec(query); err != nil {
+			t.Errorf("Expected the latest schema, got: %v", err)
+		}
+	}
+
+	if applied, err := migrateDatabase(ctx, conn, false); err != nil || len(applied) != 0 {
+		t.Errorf("Expected no migration on an up to date database, got: %d %v", len(applied), err)
+	}
+
+	// The hardcoded token of the first release no longer authenticates, while
+	// other plaintext tokens are kept for migrateTokenHashes
+	var legacy, revoked string
+	if err := conn.QueryRow("SELECT token FROM users WHERE username = 'legacy'").Scan(&legacy); err != nil || legacy != "legacy-token" {
+		t.Errorf("Expected the token of legacy to be kept, got: %q %v", legacy, err)
+	}
+	if err := conn.QueryRow("SELECT token FROM users WHERE username = 'a'").Scan(&revoked); err != nil || revoked != "" {
+		t.Errorf("Expected the legacy token of a to be revoked, got: %q %v", revoked, err)
+	}
+
+	// Once adopted, migrations are strict: a column that already exists is
+	// an error rather than skipped
+	if _, err := conn.Exec("DELETE FROM schema_migrations WHERE version >= 9"); err != nil {
+		t.Fatalf("Error forgetting migrations: %v", err)
+	}
+	if _, err := migrateDatabase(ctx, conn, false); err == nil {
+		t.Error("Expected reapplying a migration to fail")
+	}
+
+	// A database of a later release before migrations has most of the schema
+	teams := openFixture(t, "schema_teams.sql")
+	hash, err := hashToken("123")
+	if err != nil {
+		t.Fatalf("Error hashing token: %v", err)
+	}
+	if _, err := teams.Exec("INSERT INTO users (username, token_prefix, token_hash) VALUES ('a', '123', ?)", hash); err != nil {
+		t.Fatalf("Error storing legacy user: %v", err)
+	}
+	if applied, err := migrateDatabase(ctx, teams, false); err != nil || len(applied) != latest {
+		t.Fatalf("Expected the teams release database to be adopted, got: %d %v", len(applied), err)
+	}
+	task, err = scanTask(teams.QueryRow("SELECT " + taskColumns + " FROM tasks WHERE id = 7"))
+	if err != nil || task.Method != "POST" || task.TeamID != 1 {
+		t.Errorf("Unexpected migrated team task: %+v %v", task, err)
+	}
+	run, err := scanRun(teams.QueryRow("SELECT " + runColumns + " FROM task_runs WHERE task_id = 7"))
+	if err != nil || run.Trigger != runTriggerSchedule || run.StatusCode != 200 {
+		t.Errorf("Unexpected migrated run: %+v %v", run, err)
+	}
+	if err := teams.QueryRow("SELECT token_hash FROM users WHERE username = 'a'").Scan(&hash); err != nil || hash != "" {
+		t.Errorf("Expected the hashed legacy token of a to be revoked, got: %q %v", hash, err)
+	}
+
+	// Including a column of a migration that is otherwise missing
+	partial := openFixture(t, "schema_v0.sql", "ALTER TABLE tasks ADD COLUMN schedule TEXT DEFAULT ''")
+	if _, err := migrateDatabase(ctx, partial, false); err != nil {
+		t.Errorf("Expected a partially migrated database to migrate, got: %v", err)
+	}
+}
+
+func TestMigrationsFromEveryRelease(t *testing.T) {
+	ctx := context.Background()
+	latest := latestSchemaVersion()
+
+	// What each release before migrations added to the schema at startup
+	releases := []struct {
+		name       string
+		statements []string
+	}{
+		{"cron schedules", []string{"ALTER TABLE tasks ADD COLUMN schedule TEXT DEFAULT ''"}},
+		{"time zones", []string{"ALTER TABLE tasks ADD COLUMN timezone TEXT DEFAULT 'UTC'"}},
+		{"run history", []string{
+			"CREATE TABLE task_runs (id INTEGER PRIMARY KEY AUTOINCREMENT, task_id INTEGER, user_id INTEGER, scheduled_at INTEGER, started_at INTEGER, duration_ms INTEGER, status_code INTEGER, status TEXT, response_body TEXT, error TEXT, attempt INTEGER)",
+			"CREATE INDEX idx_task_runs_task ON task_runs(task_id, started_at)",
+		}},
+		{"retries", []string{"ALTER TABLE tasks ADD COLUMN retry_policy TEXT DEFAULT '{}'"}},
+		{"requests", []string{
+			"ALTER TABLE tasks ADD COLUMN method TEXT DEFAULT 'GET'",
+			"ALTER TABLE tasks ADD COLUMN headers TEXT DEFAULT '{}'",
+			"ALTER TABLE tasks ADD COLUMN query TEXT DEFAULT '{}'",
+			"ALTER TABLE tasks ADD COLUMN body TEXT DEFAULT ''",
+		}},
+		{"timeouts", []string{"ALTER TABLE tasks ADD COLUMN timeout INTEGER DEFAULT 0"}},
+		{"overlap", []string{"ALTER TABLE tasks ADD COLUMN overlap TEXT DEFAULT 'skip'"}},
+		{"misfire", []string{
+			"ALTER TABLE tasks ADD COLUMN misfire TEXT DEFAULT 'fire_once'",
+			"ALTER TABLE tasks ADD COLUMN misfire_threshold INTEGER DEFAULT 0",
+			"ALTER TABLE tasks ADD COLUMN misfire_limit INTEGER DEFAULT 0",
+		}},
+		{"hashed tokens", []string{
+			"ALTER TABLE users ADD COLUMN token_prefix TEXT",
+			"ALTER TABLE users ADD COLUMN token_hash TEXT",
+			"CREATE INDEX idx_users_token_prefix ON users(token_prefix)",
+		}},
+		{"admins", []string{"ALTER TABLE users ADD COLUMN is_admin BOOLEAN DEFAULT FALSE"}},
+		{"api keys", []string{
+			"CREATE TABLE api_keys (id INTEGER PRIMARY KEY AUTOINCREMENT, user_id INTEGER, name TEXT, key_prefix TEXT, key_hash TEXT, scopes TEXT, created_at INTEGER, expires_at INTEGER DEFAULT 0, last_used_at INTEGER DEFAULT 0, revoked_at INTEGER DEFAULT 0, UNIQUE(user_id, name))",
+			"CREATE INDEX idx_api_keys_prefix ON api_keys(key_prefix)",
+		}},
+		{"teams", []string{
+			"CREATE TABLE teams (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT UNIQUE, created_at INTEGER)",
+			"CREATE TABLE team_members (team_id INTEGER, user_id INTEGER, role TEXT, PRIMARY KEY (team_id, user_id))",
+			"ALTER TABLE tasks ADD COLUMN team_id INTEGER DEFAULT 0",
+		}},
+	}
+
+	var schema []string
+	for _, release := range releases {
+		schema = append(schema, release.statements...)
+		conn := openFixture(t, "schema_v0.sql", schema...)
+		if _, err := migrateDatabase(ctx, conn, false); err != nil {
+			t.Errorf("Expected the %s release database to migrate, got: %v", release.name, err)
+			continue
+		}
+		if version, err := schemaVersion(ctx, conn); err != nil || version != latest {
+			t.Errorf("Expected the %s release database at version %d, got: %d %v", release.name, latest, version, err)
+		}
+		if _, err := scanTask(conn.QueryRow("SELECT " + taskColumns + " FROM tasks WHERE id = 1")); err != nil {
+			t.Errorf("Expected the %s release task to migrate, got: %v", release.name, err)
+		}
+	}
+}
+
+func TestMain(m *testing.M) {
+	// Parse command-line flags
+	flag.Parse()
+
+	// The tests run against a database of their own, never the server's
+	config := defaultConfig()
+	InitializeLogger(config.Log)
+	dir, err := os.MkdirTemp("", "tasks-test")
+	if err != nil {
+		logx.Fatal("Error creating test database directory:", err)
+	}
+	config.Database.Path = filepath.Join(dir, "tasks.db")
+	initDatabase(config.Database)
+	// Manual runs go through the worker pool, even without a scheduler
+	pool = newWorkerPool(config.Scheduler.Workers, config.Scheduler.QueueSize, config.Scheduler.UserLimit, executeTask)
+	if _, err := storeUser(defaultUsername, defaultToken); err != nil {
+		logx.Fatal("Error creating default test user:", err)
+	}
+	code := m.Run() // Run tests
 
+	db.Close()
+	os.RemoveAll(dir)
 	os.Exit(code)
 }
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// API key scopes
+const (
+	scopeTasksRead  = "tasks:read"
+	scopeTasksWrite = "tasks:write"
+	scopeAdmin      = "admin" // Manage API keys and team members
+)
+
+// allScopes are granted to user tokens and are the valid key scopes
+var allScopes = []string{scopeTasksRead, scopeTasksWrite, scopeAdmin}
+
+// apiKeyPrefix starts every API key, telling them apart from user tokens
+const apiKeyPrefix = "sk_"
+
+// errKeyExpired is returned when an expired API key is used
+var errKeyExpired = errors.New("api key expired")
+
+// apiKeyColumns lists the api_keys columns in the order expected by scanAPIKey.
+const apiKeyColumns = "id, user_id, name, key_prefix, scopes, created_at, expires_at, last_used_at, revoked_at"
+
+// scanAPIKey reads a row selected with apiKeyColumns into an APIKey.
+func scanAPIKey(row rowScanner) (APIKey, error) {
+	var key APIKey
+	var scopes string
+	err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &scopes, &key.CreatedAt, &key.ExpiresAt, &key.LastUsedAt, &key.RevokedAt)
+	if scopes != "" {
+		key.Scopes = strings.Split(scopes, ",")
+	}
+	return key, err
+}
+
+// validateScopes checks requested key scopes, removing duplicates
+func validateScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+	seen := make(map[string]bool)
+	var valid []string
+	for _, scope := range scopes {
+		known := false
+		for _, s := range allScopes {
+			known = known || s == scope
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown scope %q", scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			valid = append(valid, scope)
+		}
+	}
+	return valid, nil
+}
+
+// authenticateAPIKey finds the user and scopes of an active API key and
+// records its use
+func authenticateAPIKey(key string) (User, []string, error) {
+	rows, err := db.Query("SELECT k.id, k.key_hash, k.scopes, k.expires_at, u.id, u.username FROM api_keys k JOIN users u ON u.id = k.user_id WHERE k.key_prefix = ? AND k.revoked_at = 0", tokenPrefix(key))
+	if err != nil {
+		return User{}, nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var keyID int
+		var hash, scopes string
+		var expiresAt int64
+		var user User
+		if err := rows.Scan(&keyID, &hash, &scopes, &expiresAt, &user.ID, &user.Username); err != nil {
+			return User{}, nil, err
+		}
+		if !verifyToken(key, hash) {
+			continue
+		}
+		now := time.Now().Unix()
+		if expiresAt != 0 && expiresAt <= now {
+			return User{}, nil, errKeyExpired
+		}
+		rows.Close()
+		if _, err := db.Exec("UPDATE api_keys SET last_used_at = ? WHERE id = ?", now, keyID); err != nil {
+			logx.Println("Error updating last use of API key ID:", keyID, err)
+		}
+		return user, strings.Split(scopes, ","), nil
+	}
+	if err := rows.Err(); err != nil {
+		return User{}, nil, err
+	}
+	return User{}, nil, errInvalidCredentials
+}
+
+// requireScope rejects requests whose credentials lack the given scope
+func requireScope(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		scopes, _ := c.Locals("scopes").([]string)
+		for _, s := range scopes {
+			if s == scope {
+				return c.Next()
+			}
+		}
+		logx.Printf("User ID %d denied on %s: missing scope %s\n", currentUserID(c), c.Path(), scope)
+		return c.Status(http.StatusForbidden).JSON(fiber.Map{"error": "Missing scope: " + scope})
+	}
+}
+
+// listAPIKeysHandler lists the API keys of the authenticated user
+func listAPIKeysHandler(c *fiber.Ctx) error {
+	userID := currentUserID(c)
+	rows, err := db.Query("SELECT "+apiKeyColumns+" FROM api_keys WHERE user_id = ? ORDER BY id", userID)
+	if err != nil {
+		logx.Println("Error retrieving API keys for user ID:", userID, "Error:", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve API keys"})
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			logx.Println("Error scanning API key for user ID:", userID, "Error:", err)
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to scan API keys"})
+		}
+		keys = append(keys, key)
+	}
+	return c.JSON(fiber.Map{"keys": keys})
+}
+
+// createAPIKeyHandler creates a named, scoped API key. The key itself is
+// only returned in this response.
+func createAPIKeyHandler(c *fiber.Ctx) error {
+	var req struct {
+		Name      string   `json:"name"`
+		Scopes    []string `json:"scopes"`
+		ExpiresAt int64    `json:"expires_at"` // Unix timestamp, 0 for no expiry
+	}
+	if err := c.BodyParser(&req); err != nil {
+		logx.Println("Error parsing request body in createAPIKeyHandler:", err)
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Name is required"})
+	}
+	scopes, err := validateScopes(req.Scopes)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scopes: " + err.Error()})
+	}
+	now := time.Now().Unix()
+	if req.ExpiresAt != 0 && req.ExpiresAt <= now {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Expiry must be in the future"})
+	}
+
+	userID := currentUserID(c)
+	var existing int
+	err = db.QueryRow("SELECT id FROM api_keys WHERE user_id = ? AND name = ?", userID, req.Name).Scan(&existing)
+	if err == nil {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{"error": "API key with the same name already exists"})
+	} else if err != sql.ErrNoRows {
+		logx.Println("Error checking for existing API key:", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to check for existing API key"})
+	}
+
+	secret, err := generateRandomToken()
+	if err != nil {
+		logx.Println("Error generating API key:", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to generate API key"})
+	}
+	secret = apiKeyPrefix + secret
+	hash, err := hashToken(secret)
+	if err != nil {
+		logx.Println("Error hashing API key:", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to generate API key"})
+	}
+
+	key := APIKey{UserID: userID, Name: req.Name, Prefix: tokenPrefix(secret), Scopes: scopes, CreatedAt: now, ExpiresAt: req.ExpiresAt}
+	result, err := db.Exec(`INSERT INTO api_keys(user_id, name, key_prefix, key_hash, scopes, created_at, expires_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?)`, key.UserID, key.Name, key.Prefix, hash, strings.Join(scopes, ","), key.CreatedAt, key.ExpiresAt)
+	if err != nil {
+		logx.Println("Error storing API key:", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create API key"})
+	}
+	id, _ := result.LastInsertId()
+	key.ID = int(id)
+
+	logx.Printf("API key %d (%s) created for user ID %d with scopes %v\n", key.ID, key.Name, userID, scopes)
+	return c.JSON(fiber.Map{"message": "API key created successfully", "key": secret, "api_key": key})
+}
+
+// revokeAPIKeyHandler revokes one of the authenticated user's API keys
+func revokeAPIKeyHandler(c *fiber.Ctx) error {
+	keyID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid key ID"})
+	}
+
+	userID := currentUserID(c)
+	result, err := db.Exec("UPDATE api_keys SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at = 0", time.Now().Unix(), keyID, userID)
+	if err != nil {
+		logx.Println("Error revoking API key ID:", keyID, err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to revoke API key"})
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{"error": "API key not found"})
+	}
+
+	logx.Printf("API key %d revoked for user ID %d\n", keyID, userID)
+	return c.JSON(fiber.Map{"message": "API key revoked successfully"})
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// errorCodes are the machine-readable codes of /api/v1 error responses
+var errorCodes = map[int]string{
+	http.StatusBadRequest:          "invalid_request",
+	http.StatusUnauthorized:        "unauthorized",
+	http.StatusForbidden:           "forbidden",
+	http.StatusNotFound:            "not_found",
+	http.StatusMethodNotAllowed:    "method_not_allowed",
+	http.StatusConflict:            "conflict",
+	http.StatusUnprocessableEntity: "validation_failed",
+	http.StatusTooManyRequests:     "rate_limited",
+	http.StatusServiceUnavailable:  "unavailable",
+}
+
+// errorCode returns the code of an error status
+func errorCode(status int) string {
+	if code, ok := errorCodes[status]; ok {
+		return code
+	}
+	if status >= 500 {
+		return "internal_error"
+	}
+	return "error"
+}
+
+// errorEnvelope wraps the handlers of /api/v1 so every error is returned as
+// {"error": {"code": ..., "message": ...}}. Other fields of the handler's
+// error response, such as field errors, are kept inside the error object.
+func errorEnvelope(c *fiber.Ctx) error {
+	if err := c.Next(); err != nil {
+		var fe *fiber.Error
+		if !errors.As(err, &fe) {
+			return err
+		}
+		return c.Status(fe.Code).JSON(fiber.Map{"error": fiber.Map{"code": errorCode(fe.Code), "message": fe.Message}})
+	}
+
+	status := c.Response().StatusCode()
+	if status < http.StatusBadRequest {
+		return nil
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(c.Response().Body(), &body); err != nil {
+		return nil
+	}
+	message, ok := body["error"].(string)
+	if !ok {
+		return nil
+	}
+	envelope := fiber.Map{"code": errorCode(status), "message": message}
+	for key, value := range body {
+		if key != "error" {
+			envelope[key] = value
+		}
+	}
+	return c.Status(status).JSON(fiber.Map{"error": envelope})
+}
+
+// withStatus replaces the 200 status of a successful response, e.g. with
+// 201 for created resources.
+func withStatus(status int, handler fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := handler(c); err != nil {
+			return err
+		}
+		if c.Response().StatusCode() == http.StatusOK {
+			c.Status(status)
+		}
+		return nil
+	}
+}
+
+// taskIDHandler adapts a handler taking a task ID to a route with an :id
+// path parameter.
+func taskIDHandler(handler func(c *fiber.Ctx, taskID int) error) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		taskID, err := c.ParamsInt("id")
+		if err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid task ID"})
+		}
+		return handler(c, taskID)
+	}
+}
+
+// deleteTaskV1Handler deletes a task and answers 204 No Content
+func deleteTaskV1Handler(c *fiber.Ctx, taskID int) error {
+	if err := deleteTaskByID(c, taskID); err != nil || c.Response().StatusCode() != http.StatusOK {
+		return err
+	}
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// registerV1Routes sets up the resource-oriented /api/v1 API. It shares
+// the handlers of the legacy routes, which remain as aliases.
+func registerV1Routes(app *fiber.App, auth, read, write, admin fiber.Handler) {
+	v1 := app.Group("/api/v1", errorEnvelope)
+
+	v1.Post("/users", withStatus(http.StatusCreated, registerHandler))
+	v1.Post("/login", loginHandler)
+
+	v1.Get("/tasks", auth, read, fetchTasksHandler)
+	v1.Post("/tasks", auth, write, withStatus(http.StatusCreated, scheduleHandler))
+	v1.Get("/tasks/:id", auth, read, getTaskHandler)
+	v1.Patch("/tasks/:id", auth, write, updateTaskHandler)
+	v1.Delete("/tasks/:id", auth, write, taskIDHandler(deleteTaskV1Handler))
+	v1.Post("/tasks/:id\\:enable", auth, write, taskIDHandler(func(c *fiber.Ctx, taskID int) error {
+		return updateTaskEnabled(c, taskID, true)
+	}))
+	v1.Post("/tasks/:id\\:disable", auth, write, taskIDHandler(func(c *fiber.Ctx, taskID int) error {
+		return updateTaskEnabled(c, taskID, false)
+	}))
+	v1.Post("/tasks/:id\\:run", auth, write, taskIDHandler(runTaskHandler))
+	v1.Get("/tasks/:id/runs", auth, read, taskRunsHandler)
+
+	v1.Get("/keys", auth, admin, listAPIKeysHandler)
+	v1.Post("/keys", auth, admin, withStatus(http.StatusCreated, createAPIKeyHandler))
+	v1.Delete("/keys/:id", auth, admin, revokeAPIKeyHandler)
+
+	v1.Get("/teams", auth, read, listTeamsHandler)
+	v1.Post("/teams", auth, write, withStatus(http.StatusCreated, createTeamHandler))
+	v1.Get("/teams/:id/members", auth, read, teamMembersHandler)
+	v1.Put("/teams/:id/members", auth, admin, setTeamMemberHandler)
+	v1.Delete("/teams/:id/members/:user_id", auth, admin, removeTeamMemberHandler)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// tokenPrefixLength is how many leading characters of a token are stored in
+// clear to look up its user; the token itself is only kept as a salted hash
+const tokenPrefixLength = 8
+
+// errInvalidCredentials is returned when a token does not match any user
+var errInvalidCredentials = errors.New("invalid username or token")
+
+// allowBodyCredentials accepts the deprecated username and token fields in
+// request bodies and query strings. It is enabled with
+// auth.allow_body_credentials while clients migrate to bearer tokens.
+var allowBodyCredentials bool
+
+// tokenPrefix returns the part of a token stored in clear
+func tokenPrefix(token string) string {
+	if len(token) > tokenPrefixLength {
+		return token[:tokenPrefixLength]
+	}
+	return token
+}
+
+// hashToken returns a random salt and the SHA-256 hash of the salted token,
+// both hex encoded and separated by a colon
+func hashToken(token string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(append(salt, token...))
+	return hex.EncodeToString(salt) + ":" + hex.EncodeToString(sum[:]), nil
+}
+
+// verifyToken reports whether a token matches a hash made by hashToken
+func verifyToken(token, stored string) bool {
+	saltHex, hashHex, ok := strings.Cut(stored, ":")
+	if !ok {
+		return false
+	}
+	salt, err := hex.DecodeString(saltHex)
+	if err != nil {
+		return false
+	}
+	expected, err := hex.DecodeString(hashHex)
+	if err != nil {
+		return false
+	}
+	sum := sha256.Sum256(append(salt, token...))
+	return subtle.ConstantTimeCompare(sum[:], expected) == 1
+}
+
+// storeUser creates a user, keeping only the prefix and hash of its token
+func storeUser(username, token string) (int64, error) {
+	hash, err := hashToken(token)
+	if err != nil {
+		return 0, err
+	}
+	result, err := db.Exec("INSERT INTO users(username, token, token_prefix, token_hash) VALUES(?, '', ?, ?)", username, tokenPrefix(token), hash)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// authenticateToken finds the user owning a token
+func authenticateToken(token string) (User, error) {
+	if token == "" {
+		return User{}, errInvalidCredentials
+	}
+	rows, err := db.Query("SELECT id, username, token_hash FROM users WHERE token_prefix = ?", tokenPrefix(token))
+	if err != nil {
+		return User{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user User
+		var hash string
+		if err := rows.Scan(&user.ID, &user.Username, &hash); err != nil {
+			return User{}, err
+		}
+		if verifyToken(token, hash) {
+			return user, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return User{}, err
+	}
+	return User{}, errInvalidCredentials
+}
+
+// authenticateCredentials checks a username and token pair
+func authenticateCredentials(username, token string) (User, error) {
+	user := User{Username: username}
+	var hash string
+	err := db.QueryRow("SELECT id, token_hash FROM users WHERE username = ?", username).Scan(&user.ID, &hash)
+	if err != nil || !verifyToken(token, hash) {
+		return User{}, errInvalidCredentials
+	}
+	return user, nil
+}
+
+// migrateTokenHashes replaces the plaintext tokens left by older versions
+// with their prefix and salted hash, returning how many were replaced
+func migrateTokenHashes() (int, error) {
+	rows, err := db.Query("SELECT id, token FROM users WHERE token != '' AND (token_hash IS NULL OR token_hash = '')")
+	if err != nil {
+		return 0, err
+	}
+	plaintext := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var token string
+		if err := rows.Scan(&id, &token); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		plaintext[id] = token
+	}
+	rows.Close()
+
+	for id, token := range plaintext {
+		hash, err := hashToken(token)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := db.Exec("UPDATE users SET token = '', token_prefix = ?, token_hash = ? WHERE id = ?", tokenPrefix(token), hash, id); err != nil {
+			return 0, err
+		}
+	}
+	return len(plaintext), nil
+}
+
+// bearerToken extracts the token of an "Authorization: Bearer" header
+func bearerToken(c *fiber.Ctx) string {
+	header := c.Get(fiber.HeaderAuthorization)
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// requireAuth authenticates requests with a bearer user token or API key and
+// stores the user and granted scopes in the context for the handlers. Body
+// or query credentials are only accepted when allowBodyCredentials is set.
+func requireAuth() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var user User
+		var err error
+		scopes := allScopes // User tokens grant every scope
+		if token := bearerToken(c); strings.HasPrefix(token, apiKeyPrefix) {
+			user, scopes, err = authenticateAPIKey(token)
+			if err == errInvalidCredentials {
+				// Random user tokens can start with the API key prefix too
+				user, err = authenticateToken(token)
+				scopes = allScopes
+			}
+		} else if token != "" {
+			user, err = authenticateToken(token)
+		} else if allowBodyCredentials {
+			var creds struct {
+				Username string `json:"username"`
+				Token    string `json:"token"`
+			}
+			if len(c.Body()) > 0 {
+				c.BodyParser(&creds)
+			} else {
+				creds.Username, creds.Token = c.Query("username"), c.Query("token")
+			}
+			user, err = authenticateCredentials(creds.Username, creds.Token)
+			if err == nil {
+				logx.Printf("Deprecated body credentials used by user %s on %s\n", user.Username, c.Path())
+				c.Set("Deprecation", "true")
+			}
+		} else {
+			err = errInvalidCredentials
+		}
+
+		if err != nil {
+			logx.Println("Unauthorized access attempt on", c.Path(), "Error:", err)
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid username or token"})
+		}
+		c.Locals("user_id", user.ID)
+		c.Locals("username", user.Username)
+		c.Locals("scopes", scopes)
+		return c.Next()
+	}
+}
+
+// currentUserID returns the ID of the user authenticated by requireAuth
+func currentUserID(c *fiber.Ctx) int {
+	id, _ := c.Locals("user_id").(int)
+	return id
+}
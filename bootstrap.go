@@ -0,0 +1,124 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// errAdminExists is returned when bootstrapping an admin after one exists
+var errAdminExists = errors.New("an admin account already exists")
+
+// adminExists reports whether any admin account has been created
+func adminExists() (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM users WHERE is_admin = 1").Scan(&count)
+	return count > 0, err
+}
+
+// isAdmin reports whether a user is an admin. Admins own every task and team.
+func isAdmin(userID int) (bool, error) {
+	var admin bool
+	err := db.QueryRow("SELECT is_admin FROM users WHERE id = ?", userID).Scan(&admin)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return admin, err
+}
+
+// createAdmin creates the initial admin account. An empty token is replaced
+// by a random one; the token in use is returned.
+func createAdmin(username, token string) (string, error) {
+	exists, err := adminExists()
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		return "", errAdminExists
+	}
+	if username == "" {
+		return "", errors.New("admin username is required")
+	}
+	if token == "" {
+		if token, err = generateRandomToken(); err != nil {
+			return "", err
+		}
+	}
+
+	id, err := storeUser(username, token)
+	if err != nil {
+		return "", err
+	}
+	if _, err := db.Exec("UPDATE users SET is_admin = 1 WHERE id = ?", id); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// bootstrapUsers creates the configured accounts: the initial admin on first
+// start, and the development account in dev mode.
+func bootstrapUsers(config AuthConfig) {
+	if username := config.AdminUsername; username != "" {
+		token, err := createAdmin(username, config.AdminToken)
+		switch {
+		case err == errAdminExists:
+		case err != nil:
+			logx.Fatal("Error creating admin account:", err)
+		default:
+			logx.Printf("Admin account %s created\n", username)
+			if config.AdminToken == "" {
+				// Printed once to the console only, never to the log file
+				fmt.Printf("Generated token for admin %s: %s\n", username, token)
+			}
+		}
+	}
+
+	if !config.DevMode {
+		return
+	}
+	username, token := config.DevUsername, config.DevToken
+	if token == "" {
+		logx.Println("Dev mode is enabled but auth.dev_token is not set, no development account created")
+		return
+	}
+	if _, err := authenticateCredentials(username, token); err == nil {
+		return
+	}
+	if _, err := storeUser(username, token); err != nil {
+		logx.Println("Error creating development account:", err)
+		return
+	}
+	logx.Printf("Development account %s created\n", username)
+}
+
+// runSetupAdmin implements the one-time "setup-admin" command, which creates
+// the initial admin account and prints its token
+func runSetupAdmin(args []string) int {
+	fs := flag.NewFlagSet("setup-admin", flag.ContinueOnError)
+	username := fs.String("username", "admin", "name of the admin account")
+	token := fs.String("token", "", "token of the admin account, generated when empty")
+	path := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML config file naming the database")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	config, err := readConfig(*path)
+	if err == nil {
+		err = config.validate()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:", err)
+		return 2
+	}
+	InitializeLogger(config.Log)
+	initDatabase(config.Database)
+
+	generated, err := createAdmin(*username, *token)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error creating admin account:", err)
+		return 1
+	}
+	fmt.Printf("Admin account %s created, token: %s\n", *username, generated)
+	return 0
+}
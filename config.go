@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the server configuration. Values come from the defaults, a
+// YAML file, environment variables and command-line flags, each overriding
+// the previous one. Every key can be set with a flag of the same name, e.g.
+// -scheduler.workers=20; the env tag names its environment variable.
+type Config struct {
+	Server     ServerConfig       `yaml:"server"`
+	Database   DatabaseConfig     `yaml:"database"`
+	Log        LogConfig          `yaml:"log"`
+	Scheduler  SchedulerConfig    `yaml:"scheduler"`
+	HTTPClient httpClientSettings `yaml:"http_client"`
+	Auth       AuthConfig         `yaml:"auth"`
+}
+
+// ServerConfig configures the HTTP server
+type ServerConfig struct {
+	Port            int           `yaml:"port" env:"PORT" usage:"port the API listens on"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" usage:"how long shutdown waits for running tasks"`
+}
+
+// DatabaseConfig configures the SQLite database
+type DatabaseConfig struct {
+	Path string `yaml:"path" env:"DATABASE_PATH" usage:"SQLite database file, its directory is created when missing"`
+}
+
+// LogConfig configures the logger
+type LogConfig struct {
+	File   string `yaml:"file" env:"LOG_FILE" usage:"file the log is appended to besides the console; empty disables it"`
+	Format string `yaml:"format" env:"LOG_FORMAT" usage:"log format: text or json"`
+	Level  string `yaml:"level" env:"LOG_LEVEL" usage:"minimum log level: debug, info, warn or error"`
+}
+
+// SchedulerConfig configures the scheduler loop, its worker pool and the
+// run history
+type SchedulerConfig struct {
+	Workers          int           `yaml:"workers" env:"WORKER_POOL_SIZE" usage:"task executions running at once"`
+	QueueSize        int           `yaml:"queue_size" env:"WORKER_QUEUE_SIZE" usage:"due tasks waiting for a worker"`
+	UserLimit        int           `yaml:"user_limit" env:"WORKER_USER_LIMIT" usage:"queued plus running executions per user; 0 disables the limit"`
+	ResyncInterval   time.Duration `yaml:"resync_interval" env:"SCHEDULER_RESYNC_INTERVAL" usage:"how often the task queue is reloaded from the database"`
+	RetryDelay       time.Duration `yaml:"retry_delay" env:"SCHEDULER_RETRY_DELAY" usage:"delay before a task the worker pool rejected is offered again"`
+	RunRetentionDays int           `yaml:"run_retention_days" env:"RUN_RETENTION_DAYS" usage:"days task runs are kept; 0 keeps them forever"`
+}
+
+// AuthConfig configures authentication and the accounts created at startup
+type AuthConfig struct {
+	AllowBodyCredentials bool   `yaml:"allow_body_credentials" env:"ALLOW_BODY_CREDENTIALS" usage:"accept the deprecated username and token fields in requests"`
+	AdminUsername        string `yaml:"admin_username" env:"ADMIN_USERNAME" usage:"initial admin account, created on first start"`
+	AdminToken           string `yaml:"admin_token" env:"ADMIN_TOKEN" usage:"token of the initial admin, generated when empty"`
+	DevMode              bool   `yaml:"dev_mode" env:"DEV_MODE" usage:"create the development account"`
+	DevUsername          string `yaml:"dev_username" env:"DEV_USERNAME" usage:"name of the development account"`
+	DevToken             string `yaml:"dev_token" env:"DEV_TOKEN" usage:"token of the development account"`
+}
+
+// defaultConfig returns the configuration used when nothing is set
+func defaultConfig() Config {
+	return Config{
+		Server:   ServerConfig{Port: 3000, ShutdownTimeout: defaultShutdownTimeout},
+		Database: DatabaseConfig{Path: "./db/tasks.db"},
+		Log:      LogConfig{File: "fiber.log", Format: "text", Level: "info"},
+		Scheduler: SchedulerConfig{
+			Workers:          50,
+			QueueSize:        1000,
+			UserLimit:        10,
+			ResyncInterval:   5 * time.Minute,
+			RetryDelay:       1 * time.Second,
+			RunRetentionDays: 30,
+		},
+		HTTPClient: defaultClientSettings,
+		Auth:       AuthConfig{DevUsername: "dev"},
+	}
+}
+
+// configField is a settable configuration value and its key, such as
+// "server.port"
+type configField struct {
+	key   string
+	env   string
+	usage string
+	value reflect.Value
+}
+
+// fields lists the configuration values in declaration order
+func (c *Config) fields() []configField {
+	var fields []configField
+	sections := reflect.ValueOf(c).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		section := sections.Field(i)
+		prefix := sections.Type().Field(i).Tag.Get("yaml")
+		for j := 0; j < section.NumField(); j++ {
+			field := section.Type().Field(j)
+			fields = append(fields, configField{
+				key:   prefix + "." + field.Tag.Get("yaml"),
+				env:   field.Tag.Get("env"),
+				usage: field.Tag.Get("usage"),
+				value: section.Field(j),
+			})
+		}
+	}
+	return fields
+}
+
+// setConfigValue parses s into a configuration value. Durations accept Go
+// duration strings such as 30s, or a plain number of seconds.
+func setConfigValue(v reflect.Value, s string) error {
+	switch {
+	case v.Type() == reflect.TypeOf(time.Duration(0)):
+		if seconds, err := strconv.Atoi(s); err == nil {
+			v.SetInt(int64(time.Duration(seconds) * time.Second))
+			return nil
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	default:
+		v.SetString(s)
+	}
+	return nil
+}
+
+// readConfig loads the defaults, then the YAML file at path when it is not
+// empty, then the environment variables that are set
+func readConfig(path string) (Config, error) {
+	config := defaultConfig()
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return config, err
+		}
+		defer f.Close()
+		decoder := yaml.NewDecoder(f)
+		decoder.KnownFields(true) // Typos are errors rather than ignored keys
+		if err := decoder.Decode(&config); err != nil && err != io.EOF {
+			return config, fmt.Errorf("invalid config file %s: %w", path, err)
+		}
+	}
+
+	for _, field := range config.fields() {
+		value := os.Getenv(field.env)
+		if value == "" {
+			continue
+		}
+		if err := setConfigValue(field.value, value); err != nil {
+			return config, fmt.Errorf("invalid %s: %w", field.env, err)
+		}
+	}
+	return config, nil
+}
+
+// loadConfig builds the configuration for the given command-line arguments.
+// The file is named by -config or CONFIG_FILE. It also reports whether
+// -print-config was given.
+func loadConfig(args []string) (Config, bool, error) {
+	fs := flag.NewFlagSet("ManagerSchdule", flag.ContinueOnError)
+	path := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML config file")
+	printOnly := fs.Bool("print-config", false, "print the effective configuration and exit")
+
+	// Flags are applied once the file and environment are loaded
+	type override struct{ key, value string }
+	var overrides []override
+	var usage Config
+	for _, field := range usage.fields() {
+		key := field.key
+		set := func(s string) error {
+			overrides = append(overrides, override{key, s})
+			return nil
+		}
+		if field.value.Kind() == reflect.Bool {
+			fs.BoolFunc(key, field.usage+" ("+field.env+")", set)
+		} else {
+			fs.Func(key, field.usage+" ("+field.env+")", set)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, false, err
+	}
+
+	config, err := readConfig(*path)
+	if err != nil {
+		return config, false, err
+	}
+	values := make(map[string]reflect.Value)
+	for _, field := range config.fields() {
+		values[field.key] = field.value
+	}
+	for _, o := range overrides {
+		if err := setConfigValue(values[o.key], o.value); err != nil {
+			return config, false, fmt.Errorf("invalid value for -%s: %w", o.key, err)
+		}
+	}
+	return config, *printOnly, config.validate()
+}
+
+// validate reports every invalid setting
+func (c Config) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.Port > 0 && c.Server.Port <= 65535, "server.port must be between 1 and 65535")
+	check(c.Server.ShutdownTimeout >= 0, "server.shutdown_timeout must not be negative")
+	check(c.Database.Path != "", "database.path is required")
+	check(c.Log.Format == "text" || c.Log.Format == "json", "log.format must be text or json")
+	_, err := logrus.ParseLevel(c.Log.Level)
+	check(err == nil, "log.level: %v", err)
+	check(c.Scheduler.Workers > 0, "scheduler.workers must be positive")
+	check(c.Scheduler.QueueSize > 0, "scheduler.queue_size must be positive")
+	check(c.Scheduler.UserLimit >= 0, "scheduler.user_limit must not be negative")
+	check(c.Scheduler.ResyncInterval > 0, "scheduler.resync_interval must be positive")
+	check(c.Scheduler.RetryDelay > 0, "scheduler.retry_delay must be positive")
+	check(c.Scheduler.RunRetentionDays >= 0, "scheduler.run_retention_days must not be negative")
+	check(c.HTTPClient.MaxTimeout > 0, "http_client.max_timeout must be positive")
+	check(c.HTTPClient.DefaultTimeout > 0 && c.HTTPClient.DefaultTimeout <= c.HTTPClient.MaxTimeout,
+		"http_client.default_timeout must be positive and at most http_client.max_timeout")
+	check(c.HTTPClient.MaxIdleConns >= 0 && c.HTTPClient.MaxIdleConnsPerHost >= 0,
+		"http_client idle connection limits must not be negative")
+	check(c.HTTPClient.IdleConnTimeout >= 0, "http_client.idle_conn_timeout must not be negative")
+	check(c.HTTPClient.MaxRedirects >= 0, "http_client.max_redirects must not be negative")
+	check(!c.Auth.DevMode || c.Auth.DevUsername != "", "auth.dev_username is required in dev mode")
+	return errors.Join(errs...)
+}
+
+// printConfig writes the configuration as YAML with its tokens redacted
+func printConfig(w io.Writer, c Config) error {
+	for _, token := range []*string{&c.Auth.AdminToken, &c.Auth.DevToken} {
+		if *token != "" {
+			*token = "<redacted>"
+		}
+	}
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
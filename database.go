@@ -1,58 +1,62 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"os"
+	"path/filepath"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 var db *sql.DB
 
-func init() {
+// openDatabase opens the configured database, creating its directory
+func openDatabase(config DatabaseConfig) {
 	var err error
 	// create directory if it doesn't exist
-	_, err = os.Stat("./db")
+	dir := filepath.Dir(config.Path)
+	_, err = os.Stat(dir)
 	if os.IsNotExist(err) {
-		err = os.Mkdir("./db", 0755)
+		err = os.MkdirAll(dir, 0755)
 		if err != nil {
 			logx.Fatal("Error creating db directory:", err)
 		}
 	}
-	db, err = sql.Open("sqlite3", "./db/tasks.db")
+	db, err = sql.Open("sqlite3", config.Path)
 	if err != nil {
 		logx.Fatal("Error opening database:", err)
 	}
-	createTables()
 }
 
-func createTables() {
-	userQuery := `CREATE TABLE IF NOT EXISTS users (
-        id INTEGER PRIMARY KEY AUTOINCREMENT,
-        username TEXT UNIQUE,
-        token TEXT
-    )`
-	_, err := db.Exec(userQuery)
+// initDatabase opens the configured database and migrates it to the latest
+// schema
+func initDatabase(config DatabaseConfig) {
+	openDatabase(config)
+	applied, err := migrateDatabase(context.Background(), db, false)
 	if err != nil {
-		logx.Fatal("Error creating users table:", err)
+		logx.Fatal("Error migrating database:", err)
+	}
+	for _, m := range applied {
+		logx.Printf("Applied migration %d_%s\n", m.Version, m.Name)
 	}
 
-	taskQuery := `CREATE TABLE IF NOT EXISTS tasks (
-        id INTEGER PRIMARY KEY AUTOINCREMENT,
-        user_id INTEGER,
-        name TEXT,
-        message TEXT,
-        url TEXT,
-        interval INTEGER,
-        start INTEGER,
-        end INTEGER,
-        is_recurring BOOLEAN,
-        enabled BOOLEAN DEFAULT FALSE,  -- Default value for Enabled
-        FOREIGN KEY (user_id) REFERENCES users(id),
-        UNIQUE(user_id, name)  -- Ensure task name is unique per user
-    )`
-	_, err = db.Exec(taskQuery)
-	if err != nil {
-		logx.Fatal("Error creating tasks table:", err)
+	if _, err := migrateTokenHashes(); err != nil {
+		logx.Fatal("Error hashing user tokens:", err)
 	}
 }
+
+// taskColumns lists the tasks columns in the order expected by scanTask.
+const taskColumns = "id, user_id, name, message, url, interval, start, end, is_recurring, enabled, schedule, timezone, retry_policy, method, headers, query, body, timeout, overlap, misfire, misfire_threshold, misfire_limit, team_id"
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanTask reads a row selected with taskColumns into a Task.
+func scanTask(row rowScanner) (Task, error) {
+	var task Task
+	err := row.Scan(&task.ID, &task.UserID, &task.Name, &task.Message, &task.URL, &task.Interval, &task.Start, &task.End, &task.IsRecurring, &task.Enabled, &task.Schedule, &task.Timezone, &task.Retry, &task.Method, &task.Headers, &task.Query, &task.Body, &task.Timeout, &task.Overlap, &task.Misfire, &task.MisfireThreshold, &task.MisfireLimit, &task.TeamID)
+	return task, err
+}
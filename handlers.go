@@ -4,7 +4,10 @@ import (
 	"crypto/rand"
 	"database/sql"
 	"encoding/base64"
+	"encoding/json"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -25,33 +28,22 @@ func registerHandler(c *fiber.Ctx) error {
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
 	}
 
-	// Check if the username is "a" and set token to "123"
-	if user.Username == "a" {
-		user.Token = "123"
-	} else {
-		// Generate a random token for other users
-		token, err := generateRandomToken()
-		if err != nil {
-			logx.Println("Error generating token:", err)
-			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to generate token"})
-		}
-		user.Token = token
-	}
-
-	stmt, err := db.Prepare("INSERT INTO users(username, token) VALUES(?, ?)")
+	// Generate a random token for the user
+	token, err := generateRandomToken()
 	if err != nil {
-		logx.Println("Error preparing statement in registerHandler:", err)
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to register user"})
+		logx.Println("Error generating token:", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to generate token"})
 	}
-	defer stmt.Close()
+	user.Token = token
 
-	_, err = stmt.Exec(user.Username, user.Token)
+	// Only a salted hash of the token is stored; it is returned once here
+	_, err = storeUser(user.Username, user.Token)
 	if err != nil {
-		logx.Println("Error executing statement in registerHandler:", err)
+		logx.Println("Error storing user in registerHandler:", err)
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to register user"})
 	}
 
-	logx.Printf("User registered: %s, token: %s\n", user.Username, user.Token)
+	logx.Printf("User registered: %s\n", user.Username)
 	return c.JSON(fiber.Map{"message": "User registered successfully", "token": user.Token})
 }
 
@@ -62,13 +54,7 @@ func loginHandler(c *fiber.Ctx) error {
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
 	}
 
-	// Check if the username is "a" and set the token to "123"
-	if user.Username == "a" {
-		user.Token = "123"
-	}
-
-	var storedUser User
-	err := db.QueryRow("SELECT id FROM users WHERE username = ? AND token = ?", user.Username, user.Token).Scan(&storedUser.ID)
+	storedUser, err := authenticateCredentials(user.Username, user.Token)
 	if err != nil {
 		logx.Println("Login failed for user:", user.Username, "Error:", err)
 		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid username or token"})
@@ -76,7 +62,7 @@ func loginHandler(c *fiber.Ctx) error {
 
 	logx.Printf("User logged in: %s\n", user.Username)
 
-	rows, err := db.Query("SELECT id, message, url, interval, start, end, is_recurring, enabled FROM tasks WHERE user_id = ?", storedUser.ID)
+	rows, err := db.Query("SELECT "+taskColumns+" FROM tasks WHERE user_id = ?", storedUser.ID)
 	if err != nil {
 		logx.Println("Error retrieving tasks for user ID:", storedUser.ID, "Error:", err)
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve tasks"})
@@ -85,13 +71,13 @@ func loginHandler(c *fiber.Ctx) error {
 
 	var tasks []Task
 	for rows.Next() {
-		var task Task
-		if err := rows.Scan(&task.ID, &task.Message, &task.URL, &task.Interval, &task.Start, &task.End, &task.IsRecurring, &task.Enabled); err != nil {
+		task, err := scanTask(rows)
+		if err != nil {
 			logx.Println("Error scanning task for user ID:", storedUser.ID, "Error:", err)
 			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to scan tasks"})
 		}
 		tasks = append(tasks, task)
-		logx.Printf("Task retrieved for user ID %d: %+v\n", storedUser.ID, task)
+		logx.Printf("Task retrieved for user ID %d: %d %s\n", storedUser.ID, task.ID, task.Name)
 	}
 
 	return c.JSON(fiber.Map{
@@ -102,19 +88,7 @@ func loginHandler(c *fiber.Ctx) error {
 }
 
 func scheduleHandler(c *fiber.Ctx) error {
-	logx.Println("Received request to schedule task", string(c.Body()))
-	var user User
-	if err := c.BodyParser(&user); err != nil {
-		logx.Println("Error parsing request body in scheduleHandler:", err)
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
-	}
-
-	var storedUser User
-	err := db.QueryRow("SELECT id FROM users WHERE username = ? AND token = ?", user.Username, user.Token).Scan(&storedUser.ID)
-	if err != nil {
-		logx.Println("Unauthorized access attempt by user:", user.Username, "Error:", err)
-		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid username or token"})
-	}
+	logx.Println("Received request to schedule task for user ID:", currentUserID(c))
 
 	var task Task
 	if err := c.BodyParser(&task); err != nil {
@@ -122,14 +96,31 @@ func scheduleHandler(c *fiber.Ctx) error {
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
 	}
 
-	task.UserID = storedUser.ID
+	task.UserID = currentUserID(c)
 
-	// Check for uniqueness of user_id and task name
-	var existingTaskID int64
-	err = db.QueryRow("SELECT id FROM tasks WHERE user_id = ? AND name = ?", task.UserID, task.Name).Scan(&existingTaskID)
+	// Team tasks need at least the editor role in the team
+	if task.TeamID != 0 {
+		role, err := teamRole(task.TeamID, task.UserID)
+		if err != nil {
+			logx.Println("Error checking team role in scheduleHandler:", err)
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to check team role"})
+		}
+		if !hasRole(role, roleEditor) {
+			logx.Printf("User ID %d denied scheduling in team %d with role %q\n", task.UserID, task.TeamID, role)
+			return c.Status(http.StatusForbidden).JSON(fiber.Map{"error": "Insufficient role for this team"})
+		}
+	}
+
+	if errs := append(prepareTask(&task), validateTask(task)...); errs != nil {
+		logx.Println("Invalid task in scheduleHandler:", errs)
+		return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Validation failed", "fields": errs})
+	}
+
+	// Check for uniqueness of the task name
+	existingTaskID, err := sameNameTask(task)
 	if err == nil {
-		logx.Printf("Task with the same user_id and name already exists. Task ID: %d\n", existingTaskID)
-		return c.Status(http.StatusConflict).JSON(fiber.Map{"error": "Task with the same name already exists for this user"})
+		logx.Printf("Task with the same name already exists. Task ID: %d\n", existingTaskID)
+		return c.Status(http.StatusConflict).JSON(fiber.Map{"error": taskNameConflict(task)})
 	} else if err != sql.ErrNoRows {
 		logx.Println("Error checking for existing task in scheduleHandler:", err)
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to check for existing task"})
@@ -143,8 +134,8 @@ func scheduleHandler(c *fiber.Ctx) error {
 	}
 
 	// Prepare the insert statement within the transaction
-	stmt, err := tx.Prepare(`INSERT INTO tasks(user_id, name, message, url, interval, start, end, is_recurring, enabled)
-		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`)
+	stmt, err := tx.Prepare(`INSERT INTO tasks(user_id, name, message, url, interval, start, end, is_recurring, enabled, schedule, timezone, retry_policy, method, headers, query, body, timeout, overlap, misfire, misfire_threshold, misfire_limit, team_id)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`)
 	if err != nil {
 		logx.Println("Error preparing statement in scheduleHandler:", err)
 		tx.Rollback() // Rollback the transaction in case of error
@@ -153,7 +144,7 @@ func scheduleHandler(c *fiber.Ctx) error {
 	defer stmt.Close()
 
 	var lastInsertID int64
-	err = stmt.QueryRow(task.UserID, task.Name, task.Message, task.URL, task.Interval, task.Start, task.End, task.IsRecurring, task.Enabled).Scan(&lastInsertID)
+	err = stmt.QueryRow(task.UserID, task.Name, task.Message, task.URL, task.Interval, task.Start, task.End, task.IsRecurring, task.Enabled, task.Schedule, task.Timezone, task.Retry, task.Method, task.Headers, task.Query, task.Body, task.Timeout, task.Overlap, task.Misfire, task.MisfireThreshold, task.MisfireLimit, task.TeamID).Scan(&lastInsertID)
 	if err != nil {
 		logx.Println("Error executing statement to schedule task:", err)
 		tx.Rollback() // Rollback the transaction in case of error
@@ -165,34 +156,187 @@ func scheduleHandler(c *fiber.Ctx) error {
 		logx.Println("Error committing transaction in scheduleHandler:", err)
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to commit transaction"})
 	}
+	scheduler.refresh(int(lastInsertID))
 
 	// Prepare the response with task details
 	response := fiber.Map{
 		"message": "Task scheduled successfully",
 		"task": fiber.Map{
-			"task_id":      lastInsertID,
-			"user_id":      task.UserID,
-			"name":         task.Name, // Assuming the task struct has a Name field
-			"message":      task.Message,
-			"url":          task.URL,
-			"interval":     task.Interval,
-			"start":        task.Start,
-			"end":          task.End,
-			"is_recurring": task.IsRecurring,
-			"enabled":      task.Enabled,
+			"task_id":           lastInsertID,
+			"user_id":           task.UserID,
+			"name":              task.Name, // Assuming the task struct has a Name field
+			"message":           task.Message,
+			"url":               task.URL,
+			"interval":          task.Interval,
+			"start":             task.Start,
+			"end":               task.End,
+			"is_recurring":      task.IsRecurring,
+			"enabled":           task.Enabled,
+			"schedule":          task.Schedule,
+			"timezone":          task.Timezone,
+			"retry":             task.Retry,
+			"method":            task.Method,
+			"headers":           task.Headers,
+			"query":             task.Query,
+			"body":              task.Body,
+			"timeout":           task.Timeout,
+			"overlap":           task.Overlap,
+			"misfire":           task.Misfire,
+			"misfire_threshold": task.MisfireThreshold,
+			"misfire_limit":     task.MisfireLimit,
+			"team_id":           task.TeamID,
 		},
 	}
 
-	logx.Printf("Task scheduled: %+v\n", response["task"])
+	logx.Printf("Task scheduled for user ID %d: %d %s\n", task.UserID, lastInsertID, task.Name)
 	return c.JSON(response)
 }
 
+// sameNameTask returns the ID of another task with the task's name. Names
+// are unique within a team, and per user among personal tasks.
+func sameNameTask(task Task) (int64, error) {
+	var id int64
+	err := db.QueryRow("SELECT id FROM tasks WHERE name = ? AND id != ? AND team_id = ? AND (team_id != 0 OR user_id = ?)",
+		task.Name, task.ID, task.TeamID, task.UserID).Scan(&id)
+	return id, err
+}
+
+// taskNameConflict is the error message for a task name already in use
+func taskNameConflict(task Task) string {
+	if task.TeamID != 0 {
+		return "Task with the same name already exists in this team"
+	}
+	return "Task with the same name already exists for this user"
+}
+
+// prepareTask validates a task definition and fills in its defaults. A cron
+// schedule makes the task recurring, with its first run at the first
+// occurrence at or after the requested start.
+func prepareTask(task *Task) validationErrors {
+	var errs validationErrors
+	if task.Timezone == "" {
+		task.Timezone = "UTC"
+	}
+	if _, err := taskLocation(*task); err != nil {
+		errs.add("timezone", "unknown time zone %q", task.Timezone)
+	}
+	if err := task.Retry.validate(); err != nil {
+		errs.add("retry", "%v", err)
+	}
+	errs = append(errs, validateRequest(task)...)
+	if task.Timeout < 0 || time.Duration(task.Timeout)*time.Second > clientSettings.MaxTimeout {
+		errs.add("timeout", "must be between 0 and %d seconds", int64(clientSettings.MaxTimeout/time.Second))
+	}
+	if err := validateOverlap(task); err != nil {
+		errs.add("overlap", "%v", err)
+	}
+	errs = append(errs, validateMisfire(task)...)
+
+	if task.Schedule != "" {
+		if _, err := parseSchedule(task.Schedule); err != nil {
+			errs.add("schedule", "%v", err)
+		} else if errs == nil {
+			from := time.Now()
+			if task.Start > from.Unix() {
+				from = time.Unix(task.Start, 0).Add(-time.Second)
+			}
+			task.Start, _ = nextStart(*task, from)
+			task.IsRecurring = true
+		}
+	}
+	return errs
+}
+
+// updateTaskHandler applies a partial update to a task. Fields missing from
+// the body keep their current values; the scheduler picks up the new
+// schedule immediately.
+func updateTaskHandler(c *fiber.Ctx) error {
+	taskID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid task ID"})
+	}
+
+	userID := currentUserID(c)
+	task, err := authorizeTask(taskID, userID, roleEditor)
+	if err != nil {
+		return taskAccessError(c, err)
+	}
+	current := task
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(c.Body(), &fields); err != nil {
+		logx.Println("Error parsing request body in updateTaskHandler:", err)
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+	}
+	// Maps are replaced rather than merged
+	if _, ok := fields["headers"]; ok {
+		task.Headers = nil
+	}
+	if _, ok := fields["query"]; ok {
+		task.Query = nil
+	}
+	if err := json.Unmarshal(c.Body(), &task); err != nil {
+		logx.Println("Error parsing request body in updateTaskHandler:", err)
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+	}
+	task.ID, task.UserID = current.ID, current.UserID // Not editable
+
+	// Moving a task between teams is limited to its creator, who must be
+	// able to edit tasks in the new team
+	if task.TeamID != current.TeamID {
+		if current.UserID != userID {
+			return c.Status(http.StatusForbidden).JSON(fiber.Map{"error": "Only the task's creator can move it"})
+		}
+		if task.TeamID != 0 {
+			role, err := teamRole(task.TeamID, userID)
+			if err != nil {
+				logx.Println("Error checking team role in updateTaskHandler:", err)
+				return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to check team role"})
+			}
+			if !hasRole(role, roleEditor) {
+				return c.Status(http.StatusForbidden).JSON(fiber.Map{"error": "Insufficient role for this team"})
+			}
+		}
+	}
+
+	if errs := append(prepareTask(&task), validateTask(task)...); errs != nil {
+		logx.Println("Invalid task in updateTaskHandler:", errs)
+		return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Validation failed", "fields": errs})
+	}
+
+	if task.Name != current.Name || task.TeamID != current.TeamID {
+		existingTaskID, err := sameNameTask(task)
+		if err == nil {
+			logx.Printf("Task with the same name already exists. Task ID: %d\n", existingTaskID)
+			return c.Status(http.StatusConflict).JSON(fiber.Map{"error": taskNameConflict(task)})
+		} else if err != sql.ErrNoRows {
+			logx.Println("Error checking for existing task in updateTaskHandler:", err)
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to check for existing task"})
+		}
+	}
+
+	_, err = db.Exec(`UPDATE tasks SET name = ?, message = ?, url = ?, interval = ?, start = ?, end = ?, is_recurring = ?, enabled = ?, schedule = ?, timezone = ?, retry_policy = ?, method = ?, headers = ?, query = ?, body = ?, timeout = ?, overlap = ?, misfire = ?, misfire_threshold = ?, misfire_limit = ?, team_id = ?
+		WHERE id = ?`, task.Name, task.Message, task.URL, task.Interval, task.Start, task.End, task.IsRecurring, task.Enabled, task.Schedule, task.Timezone, task.Retry, task.Method, task.Headers, task.Query, task.Body, task.Timeout, task.Overlap, task.Misfire, task.MisfireThreshold, task.MisfireLimit, task.TeamID, task.ID)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return c.Status(http.StatusConflict).JSON(fiber.Map{"error": taskNameConflict(task)})
+		}
+		logx.Println("Error executing statement in updateTaskHandler:", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update task"})
+	}
+
+	scheduler.refresh(task.ID)
+	if current.Enabled && !task.Enabled {
+		stopExecutions(task.ID)
+	}
+	logx.Printf("Task ID %d updated by user ID %d\n", task.ID, userID)
+	return c.JSON(fiber.Map{"message": "Task updated successfully", "task": newTaskView(task)})
+}
+
 func setTaskEnabledHandler(c *fiber.Ctx) error {
 	type request struct {
-		Username string `json:"username"`
-		Token    string `json:"token"`
-		TaskID   int    `json:"task_id"`
-		Enabled  bool   `json:"enabled"`
+		TaskID  int  `json:"task_id"`
+		Enabled bool `json:"enabled"`
 	}
 
 	var req request
@@ -201,100 +345,152 @@ func setTaskEnabledHandler(c *fiber.Ctx) error {
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
 	}
 
-	var storedUser User
-	err := db.QueryRow("SELECT id FROM users WHERE username = ? AND token = ?", req.Username, req.Token).Scan(&storedUser.ID)
-	if err != nil {
-		logx.Println("Unauthorized access attempt by user:", req.Username, "Error:", err)
-		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid username or token"})
+	return updateTaskEnabled(c, req.TaskID, req.Enabled)
+}
+
+// stopExecutions cancels the running executions of a task that was deleted
+// or disabled, including those waiting to retry
+func stopExecutions(taskID int) {
+	if n := cancelTaskExecutions(taskID); n > 0 {
+		logx.Printf("Cancelled %d running execution(s) of task ID %d\n", n, taskID)
+	}
+}
+
+// updateTaskEnabled enables or disables a task the user can edit
+func updateTaskEnabled(c *fiber.Ctx, taskID int, enabled bool) error {
+	storedUser := User{ID: currentUserID(c)}
+
+	if _, err := authorizeTask(taskID, storedUser.ID, roleEditor); err != nil {
+		return taskAccessError(c, err)
 	}
 
-	stmt, err := db.Prepare("UPDATE tasks SET enabled = ? WHERE user_id = ? AND id = ?")
+	stmt, err := db.Prepare("UPDATE tasks SET enabled = ? WHERE id = ?")
 	if err != nil {
 		logx.Println("Error preparing statement in setTaskEnabledHandler:", err)
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update task"})
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(req.Enabled, storedUser.ID, req.TaskID)
+	_, err = stmt.Exec(enabled, taskID)
 	if err != nil {
 		logx.Println("Error executing statement in setTaskEnabledHandler:", err)
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update task"})
 	}
 
-	logx.Printf("Task ID %d for user ID %d set to enabled: %v\n", req.TaskID, storedUser.ID, req.Enabled)
+	scheduler.refresh(taskID)
+	if !enabled {
+		stopExecutions(taskID)
+	}
+	logx.Printf("Task ID %d for user ID %d set to enabled: %v\n", taskID, storedUser.ID, enabled)
 
 	// Include task details in the response
 	response := fiber.Map{
 		"message": "Task updated successfully",
 		"task": fiber.Map{
-			"task_id": req.TaskID,
-			"enabled": req.Enabled,
+			"task_id": taskID,
+			"enabled": enabled,
 		},
 	}
 	return c.JSON(response)
 }
 
-// FetchTasksHandler retrieves tasks for a specific user based on username and token.
-func fetchTasksHandler(c *fiber.Ctx) error {
-	var req struct {
-		Username string `json:"username"`
-		Token    string `json:"token"`
+// newTaskView renders a task's start and end times in UTC and in its own
+// time zone.
+func newTaskView(task Task) TaskView {
+	loc, err := taskLocation(task)
+	if err != nil {
+		loc = time.UTC
 	}
-	logx.Println("Received request to fetch tasks", string(c.Body()))
-	// Parse the request body
-	if err := c.BodyParser(&req); err != nil {
-		logx.Println("Error parsing request body in fetchTasksHandler:", err)
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+	start, end := time.Unix(task.Start, 0), time.Unix(task.End, 0)
+	view := TaskView{
+		Task:       task,
+		StartUTC:   start.UTC().Format(time.RFC3339),
+		StartLocal: start.In(loc).Format(time.RFC3339),
+	}
+	if task.End != 0 { // Open-ended tasks have no end time to show
+		view.EndUTC = end.UTC().Format(time.RFC3339)
+		view.EndLocal = end.In(loc).Format(time.RFC3339)
 	}
+	return view
+}
 
-	logx.Printf("Received request to fetch tasks for user: %s\n", req.Username)
+// getTaskHandler returns a single task the user can see
+func getTaskHandler(c *fiber.Ctx) error {
+	taskID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid task ID"})
+	}
+	task, err := authorizeTask(taskID, currentUserID(c), roleViewer)
+	if err != nil {
+		return taskAccessError(c, err)
+	}
+	return c.JSON(fiber.Map{"task": newTaskView(task)})
+}
+
+// FetchTasksHandler retrieves a page of the tasks visible to the
+// authenticated user: their personal tasks and the tasks of their teams. It
+// supports filters, sorting and cursor pagination; see parseTaskListQuery.
+func fetchTasksHandler(c *fiber.Ctx) error {
+	storedUser := User{ID: currentUserID(c)}
+	logx.Printf("Received request to fetch tasks for user ID: %d\n", storedUser.ID)
 
-	var storedUser User
-	// Check if the user exists and the token is valid
-	err := db.QueryRow("SELECT id FROM users WHERE username = ? AND token = ?", req.Username, req.Token).Scan(&storedUser.ID)
+	q, err := parseTaskListQuery(c, storedUser.ID)
 	if err != nil {
-		logx.Println("Unauthorized access attempt by user:", req.Username, "Error:", err)
-		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid username or token"})
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid query: " + err.Error()})
 	}
 
-	logx.Printf("User verified. User ID: %d\n", storedUser.ID)
+	var total int
+	countQuery, countArgs := q.count()
+	if err := db.QueryRow(countQuery, countArgs...).Scan(&total); err != nil {
+		logx.Println("Error counting tasks for user ID:", storedUser.ID, "Error:", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve tasks"})
+	}
 
-	// Query tasks for the user
-	rows, err := db.Query("SELECT id, message, name, url, interval, start, end, is_recurring, enabled FROM tasks WHERE user_id = ?", storedUser.ID)
+	// Query one page of tasks for the user and their teams
+	pageQuery, pageArgs := q.page()
+	rows, err := db.Query(pageQuery, pageArgs...)
 	if err != nil {
 		logx.Println("Error retrieving tasks for user ID:", storedUser.ID, "Error:", err)
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve tasks"})
 	}
 	defer rows.Close()
 
-	var tasks []Task
+	tasks := []TaskView{} // Encode as an empty list rather than null
 	for rows.Next() {
-		var task Task
-		if err := rows.Scan(&task.ID, &task.Message, &task.Name, &task.URL, &task.Interval, &task.Start, &task.End, &task.IsRecurring, &task.Enabled); err != nil {
+		task, err := scanTask(rows)
+		if err != nil {
 			logx.Println("Error scanning task for user ID:", storedUser.ID, "Error:", err)
 			// return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to scan tasks"})
 			continue
 		}
-		tasks = append(tasks, task)
-		logx.Printf("Task retrieved for user ID %d: %+v\n", storedUser.ID, task)
+		tasks = append(tasks, newTaskView(task))
+	}
+
+	nextCursor := ""
+	if len(tasks) > q.limit {
+		tasks = tasks[:q.limit]
+		last := tasks[len(tasks)-1].Task
+		nextCursor = encodeCursor(taskCursor{Sort: c.Query("sort", "id"), Value: sortValue(last, q.sort), ID: last.ID})
 	}
 
 	if len(tasks) == 0 {
 		logx.Printf("No tasks found for user ID %d\n", storedUser.ID)
 	} else {
-		logx.Printf("Total tasks retrieved for user ID %d: %d\n", storedUser.ID, len(tasks))
+		logx.Printf("Tasks retrieved for user ID %d: %d of %d\n", storedUser.ID, len(tasks), total)
 	}
 
-	return c.JSON(fiber.Map{"tasks": tasks})
+	return c.JSON(fiber.Map{
+		"tasks":       tasks,
+		"total":       total,
+		"limit":       q.limit,
+		"next_cursor": nextCursor,
+	})
 }
 
 // deleteTaskHandler deletes a task for a specific user based on task ID.
 func deleteTaskHandler(c *fiber.Ctx) error {
-	logx.Println("Received request to delete task", string(c.Body()))
 	type request struct {
-		Username string `json:"username"`
-		Token    string `json:"token"`
-		TaskID   int    `json:"task_id"`
+		TaskID int `json:"task_id"`
 	}
 	var req request
 	if err := c.BodyParser(&req); err != nil {
@@ -302,16 +498,20 @@ func deleteTaskHandler(c *fiber.Ctx) error {
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
 	}
 
-	// Verify the user's credentials
-	var storedUser User
-	err := db.QueryRow("SELECT id FROM users WHERE username = ? AND token = ?", req.Username, req.Token).Scan(&storedUser.ID)
-	if err != nil {
-		logx.Println("Unauthorized access attempt by user:", req.Username, "Error:", err)
-		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid username or token"})
+	return deleteTaskByID(c, req.TaskID)
+}
+
+// deleteTaskByID deletes a task the user can edit
+func deleteTaskByID(c *fiber.Ctx, taskID int) error {
+	storedUser := User{ID: currentUserID(c)}
+	logx.Printf("Received request to delete task ID %d for user ID %d\n", taskID, storedUser.ID)
+
+	if _, err := authorizeTask(taskID, storedUser.ID, roleEditor); err != nil {
+		return taskAccessError(c, err)
 	}
 
 	// Prepare the delete statement
-	stmt, err := db.Prepare("DELETE FROM tasks WHERE user_id = ? AND id = ?")
+	stmt, err := db.Prepare("DELETE FROM tasks WHERE id = ?")
 	if err != nil {
 		logx.Println("Error preparing statement in deleteTaskHandler:", err)
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to prepare delete task"})
@@ -319,7 +519,7 @@ func deleteTaskHandler(c *fiber.Ctx) error {
 	defer stmt.Close()
 
 	// Execute the delete statement
-	result, err := stmt.Exec(storedUser.ID, req.TaskID)
+	result, err := stmt.Exec(taskID)
 	if err != nil {
 		logx.Println("Error executing delete statement in deleteTaskHandler:", err)
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete task"})
@@ -333,11 +533,13 @@ func deleteTaskHandler(c *fiber.Ctx) error {
 	}
 
 	if rowsAffected == 0 {
-		logx.Printf("No task found with ID %d for user ID %d\n", req.TaskID, storedUser.ID)
+		logx.Printf("No task found with ID %d for user ID %d\n", taskID, storedUser.ID)
 		return c.Status(http.StatusNotFound).JSON(fiber.Map{"error": "Task not found"})
 	}
 
-	logx.Printf("Task ID %d deleted for user ID %d\n", req.TaskID, storedUser.ID)
+	scheduler.remove(taskID)
+	stopExecutions(taskID)
+	logx.Printf("Task ID %d deleted for user ID %d\n", taskID, storedUser.ID)
 
 	return c.JSON(fiber.Map{"message": "Task deleted successfully"})
 }
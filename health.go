@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Readiness thresholds
+const (
+	heartbeatInterval = 15 * time.Second // Longest the scheduler loop sleeps
+	heartbeatTimeout  = time.Minute      // Heartbeat age after which the scheduler counts as wedged
+	queueSaturation   = 0.9              // Fraction of the worker queue that counts as saturated
+	dbPingTimeout     = 2 * time.Second
+)
+
+// startedAt is when the process started
+var startedAt = time.Now()
+
+// schedulerHeartbeat is the Unix time in nanoseconds of the last scheduler
+// loop iteration, 0 before the scheduler starts
+var schedulerHeartbeat atomic.Int64
+
+// beat records a scheduler loop iteration
+func beat(now time.Time) {
+	schedulerHeartbeat.Store(now.UnixNano())
+}
+
+// healthCheck is the result of one readiness check
+type healthCheck struct {
+	Status string                 `json:"status"` // ok or failing
+	Error  string                 `json:"error,omitempty"`
+	Detail map[string]interface{} `json:"detail,omitempty"`
+}
+
+func passing(detail map[string]interface{}) healthCheck {
+	return healthCheck{Status: "ok", Detail: detail}
+}
+
+func failing(err string, detail map[string]interface{}) healthCheck {
+	return healthCheck{Status: "failing", Error: err, Detail: detail}
+}
+
+// checkDatabase pings the database
+func checkDatabase(ctx context.Context) healthCheck {
+	ctx, cancel := context.WithTimeout(ctx, dbPingTimeout)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		return failing(err.Error(), nil)
+	}
+	return passing(nil)
+}
+
+// checkSchema verifies that every embedded migration was applied
+func checkSchema(ctx context.Context) healthCheck {
+	version, err := schemaVersion(ctx, db)
+	if err != nil {
+		return failing(err.Error(), nil)
+	}
+	latest := latestSchemaVersion()
+	detail := map[string]interface{}{"version": version, "latest": latest}
+	if version != latest {
+		return failing("database schema is not up to date", detail)
+	}
+	return passing(detail)
+}
+
+// checkScheduler verifies that the scheduler loop ran recently
+func checkScheduler(now time.Time) healthCheck {
+	last := schedulerHeartbeat.Load()
+	if last == 0 {
+		return failing("scheduler has not started", nil)
+	}
+	age := now.Sub(time.Unix(0, last))
+	detail := map[string]interface{}{
+		"last_heartbeat": time.Unix(0, last).UTC().Format(time.RFC3339),
+		"age_seconds":    age.Seconds(),
+	}
+	if age > heartbeatTimeout {
+		return failing("scheduler heartbeat is stale", detail)
+	}
+	return passing(detail)
+}
+
+// checkWorkerQueue verifies that the worker pool accepts work and its queue
+// is not saturated
+func checkWorkerQueue() healthCheck {
+	if pool == nil {
+		return failing("worker pool has not started", nil)
+	}
+	stats := pool.stats()
+	detail := map[string]interface{}{
+		"queue_depth":    stats.QueueDepth,
+		"queue_capacity": stats.QueueCapacity,
+		"running":        stats.Running,
+		"workers":        stats.Workers,
+	}
+	if pool.isClosed() {
+		return failing("worker pool is shut down", detail)
+	}
+	if stats.QueueCapacity > 0 && float64(stats.QueueDepth) >= queueSaturation*float64(stats.QueueCapacity) {
+		return failing("worker queue is saturated", detail)
+	}
+	return passing(detail)
+}
+
+// healthzHandler reports that the process is alive
+func healthzHandler(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"status": "ok", "uptime_seconds": int64(time.Since(startedAt).Seconds())})
+}
+
+// readyzHandler reports whether the server can do its work, with the result
+// of every check. It answers 503 when any check fails.
+func readyzHandler(c *fiber.Ctx) error {
+	ctx := c.UserContext()
+	checks := map[string]healthCheck{
+		"database":     checkDatabase(ctx),
+		"schema":       checkSchema(ctx),
+		"scheduler":    checkScheduler(time.Now()),
+		"worker_queue": checkWorkerQueue(),
+	}
+
+	status, code := "ok", http.StatusOK
+	for _, check := range checks {
+		if check.Status != "ok" {
+			status, code = "unavailable", http.StatusServiceUnavailable
+		}
+	}
+	return c.Status(code).JSON(fiber.Map{"status": status, "checks": checks})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// httpClientSettings configures the HTTP client shared by all task executions.
+// It is the http_client section of Config.
+type httpClientSettings struct {
+	DefaultTimeout      time.Duration `yaml:"default_timeout" env:"HTTP_DEFAULT_TIMEOUT" usage:"timeout for tasks that do not set one"`
+	MaxTimeout          time.Duration `yaml:"max_timeout" env:"HTTP_MAX_TIMEOUT" usage:"upper bound for per-task timeouts"`
+	MaxIdleConns        int           `yaml:"max_idle_conns" env:"HTTP_MAX_IDLE_CONNS" usage:"idle connections kept across all hosts"`
+	MaxIdleConnsPerHost int           `yaml:"max_idle_conns_per_host" env:"HTTP_MAX_IDLE_CONNS_PER_HOST" usage:"idle connections kept per host"`
+	IdleConnTimeout     time.Duration `yaml:"idle_conn_timeout" env:"HTTP_IDLE_CONN_TIMEOUT" usage:"how long an idle connection is kept"`
+	InsecureSkipVerify  bool          `yaml:"tls_insecure_skip_verify" env:"HTTP_TLS_INSECURE_SKIP_VERIFY" usage:"skip TLS certificate verification"`
+	MaxRedirects        int           `yaml:"max_redirects" env:"HTTP_MAX_REDIRECTS" usage:"redirects followed per request; 0 disables them"`
+}
+
+// defaultClientSettings are the settings used when nothing is configured
+var defaultClientSettings = httpClientSettings{
+	DefaultTimeout:      30 * time.Second,
+	MaxTimeout:          5 * time.Minute,
+	MaxIdleConns:        100,
+	MaxIdleConnsPerHost: 10,
+	IdleConnTimeout:     90 * time.Second,
+	MaxRedirects:        10,
+}
+
+// clientSettings holds the active settings, set by initHTTPClient
+var clientSettings = defaultClientSettings
+
+// httpClient is shared by all task executions so connections are pooled
+var httpClient = newHTTPClient(clientSettings)
+
+// newHTTPClient builds a pooled client for the given settings. Timeouts are
+// applied per request through its context, not on the client.
+func newHTTPClient(settings httpClientSettings) *http.Client {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:        settings.MaxIdleConns,
+		MaxIdleConnsPerHost: settings.MaxIdleConnsPerHost,
+		IdleConnTimeout:     settings.IdleConnTimeout,
+		TLSHandshakeTimeout: 10 * time.Second,
+		TLSClientConfig: &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			InsecureSkipVerify: settings.InsecureSkipVerify,
+		},
+	}
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > settings.MaxRedirects {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+}
+
+// initHTTPClient applies the client settings and rebuilds the shared client
+func initHTTPClient(settings httpClientSettings) {
+	clientSettings = settings
+	httpClient = newHTTPClient(clientSettings)
+}
+
+// taskTimeout returns the request timeout of a task, bounded by the
+// configured maximum
+func taskTimeout(task Task) time.Duration {
+	timeout := time.Duration(task.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = clientSettings.DefaultTimeout
+	}
+	if timeout > clientSettings.MaxTimeout {
+		timeout = clientSettings.MaxTimeout
+	}
+	return timeout
+}
+
+// isTimeout reports whether a request error was caused by a timeout
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
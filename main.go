@@ -1,8 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"io"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -14,57 +21,160 @@ var (
 	logx *logrus.Logger
 )
 
-// InitializeLogger sets up the logger to write to both console and file
-func InitializeLogger() {
+// InitializeLogger sets up the logger to write to the console and, when
+// configured, to the log file
+func InitializeLogger(config LogConfig) {
 	logx = logrus.New()
 
-	// Create log file
-	var err error
-	file, err = os.OpenFile("fiber.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-	if err != nil {
-		logx.Fatalf("Error opening log file: %v", err)
-	}
+	if config.File != "" {
+		// Create log file
+		var err error
+		file, err = os.OpenFile(config.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+		if err != nil {
+			logx.Fatalf("Error opening log file: %v", err)
+		}
 
-	// Set output to both file and standard output
-	multiWriter := io.MultiWriter(os.Stdout, file)
-	logx.SetOutput(multiWriter)
+		// Set output to both file and standard output
+		logx.SetOutput(io.MultiWriter(os.Stdout, file))
+	}
 
-	// Set log format
-	logx.SetFormatter(&logrus.TextFormatter{})
+	// Set log format and level
+	if config.Format == "json" {
+		logx.SetFormatter(&logrus.JSONFormatter{})
+	} else {
+		logx.SetFormatter(&logrus.TextFormatter{})
+	}
+	if level, err := logrus.ParseLevel(config.Level); err == nil {
+		logx.SetLevel(level)
+	}
 }
 
-// Middleware for Fiber to use logrus
+// Middleware for Fiber to use logrus. It also records the request latency
+// metric.
 func LogrusLogger() fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		start := time.Now()
 		err := c.Next() // Call the next handler
 
+		// Errors returned to Fiber are only turned into a response later
+		status := c.Response().StatusCode()
+		var fe *fiber.Error
+		if errors.As(err, &fe) {
+			status = fe.Code
+		} else if err != nil {
+			status = fiber.StatusInternalServerError
+		}
+		latency := time.Since(start)
+		observeRequest(c, status, latency)
+
 		logx.WithFields(logrus.Fields{
 			"method":  c.Method(),
 			"path":    c.Path(),
-			"status":  c.Response().StatusCode(),
-			"latency": time.Since(start),
+			"status":  status,
+			"latency": latency,
 		}).Info("Request Info")
 
 		return err
 	}
 }
-func main() {
-	InitializeLogger() // Set up logger
+
+// newApp builds the app serving the web interface and the API
+func newApp() *fiber.App {
 	app := fiber.New()
 	app.Use(LogrusLogger())
 	// Serve the HTML file
 	app.Static("/", "./templates/index.html")
 
-	// API routes
+	registerRoutes(app)
+	return app
+}
+
+// registerRoutes sets up the API routes. Everything except registration,
+// login, the OpenAPI document, metrics and health checks requires a bearer
+// token or an API key with the matching scope. New routes must also be
+// listed in apiOperations.
+func registerRoutes(app *fiber.App) {
+	app.Get("/openapi.json", openAPIHandler)
+	app.Get("/metrics", metricsHandler)
+	app.Get("/healthz", healthzHandler)
+	app.Get("/readyz", readyzHandler)
 	app.Post("/register", registerHandler)
 	app.Post("/login", loginHandler)
-	app.Post("/schedule", scheduleHandler)
-	app.Delete("/api/tasks/delete", deleteTaskHandler)
-	app.Post("/api/tasks/set-enabled", setTaskEnabledHandler)
-	app.Post("/api/tasks", fetchTasksHandler) // New route for fetching tasks
-
-	go startTaskScheduler() // Start the task scheduler in a goroutine
-	logx.Println("Server started on port 3000")
-	logx.Fatal(app.Listen(":3000"))
+
+	auth := requireAuth()
+	read, write, admin := requireScope(scopeTasksRead), requireScope(scopeTasksWrite), requireScope(scopeAdmin)
+	app.Post("/schedule", auth, write, scheduleHandler)
+	app.Delete("/api/tasks/delete", auth, write, deleteTaskHandler)
+	app.Post("/api/tasks/set-enabled", auth, write, setTaskEnabledHandler)
+	app.Post("/api/tasks", auth, read, fetchTasksHandler) // New route for fetching tasks
+	app.Patch("/api/tasks/:id", auth, write, updateTaskHandler)
+	app.Get("/api/tasks/:id/runs", auth, read, taskRunsHandler)
+
+	app.Get("/api/teams", auth, read, listTeamsHandler)
+	app.Post("/api/teams", auth, write, createTeamHandler)
+	app.Get("/api/teams/:id/members", auth, read, teamMembersHandler)
+	app.Post("/api/teams/:id/members", auth, admin, setTeamMemberHandler)
+	app.Delete("/api/teams/:id/members/:user_id", auth, admin, removeTeamMemberHandler)
+
+	app.Get("/api/keys", auth, admin, listAPIKeysHandler)
+	app.Post("/api/keys", auth, admin, createAPIKeyHandler)
+	app.Delete("/api/keys/:id", auth, admin, revokeAPIKeyHandler)
+
+	registerV1Routes(app, auth, read, write, admin)
+}
+
+func main() {
+	// One-time setup command: create the initial admin and exit
+	if len(os.Args) > 1 && os.Args[1] == "setup-admin" {
+		os.Exit(runSetupAdmin(os.Args[2:]))
+	}
+	// Migrate the database, or list pending migrations, and exit
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:]))
+	}
+
+	config, printOnly, err := loadConfig(os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:", err)
+		os.Exit(2)
+	}
+	if printOnly {
+		if err := printConfig(os.Stdout, config); err != nil {
+			fmt.Fprintln(os.Stderr, "Error printing configuration:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	InitializeLogger(config.Log)      // Set up logger
+	initDatabase(config.Database)     // Open and migrate the database
+	initHTTPClient(config.HTTPClient) // Configure the HTTP client used by tasks
+	allowBodyCredentials = config.Auth.AllowBodyCredentials
+	bootstrapUsers(config.Auth) // Create the configured admin and dev accounts
+	// The pool exists before anything that submits to or reports on it starts
+	pool = newWorkerPool(config.Scheduler.Workers, config.Scheduler.QueueSize, config.Scheduler.UserLimit, executeTask)
+	app := newApp()
+
+	// Stop on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	background, stopBackground := context.WithCancel(context.Background())
+
+	go startTaskScheduler(background, pool, config.Scheduler)        // Start the task scheduler in a goroutine
+	go startRunPruner(background, config.Scheduler.RunRetentionDays) // Remove run history past its retention period
+
+	go func() {
+		if err := app.Listen(":" + strconv.Itoa(config.Server.Port)); err != nil {
+			logx.Fatal(err)
+		}
+	}()
+	logx.Printf("Server started on port %d\n", config.Server.Port)
+
+	<-ctx.Done()
+	stop() // A second signal kills the process
+	timeout := config.Server.ShutdownTimeout
+	logx.Printf("Shutting down, waiting up to %s for running tasks\n", timeout)
+	shutdown(app, stopBackground, timeout)
 }
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus metrics, served at /metrics
+var (
+	taskExecutions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "scheduler_task_executions_total",
+		Help: "Recorded task runs by outcome and trigger.",
+	}, []string{"outcome", "trigger"})
+
+	taskExecutionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "scheduler_task_execution_duration_seconds",
+		Help:    "Duration of task execution attempts by outcome.",
+		Buckets: []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300},
+	}, []string{"outcome"})
+
+	taskScheduleLag = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "scheduler_task_lag_seconds",
+		Help:    "Delay between the planned and the actual start of scheduled executions.",
+		Buckets: []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10, 30, 60, 300},
+	})
+
+	schedulerLoopDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "scheduler_loop_duration_seconds",
+		Help:    "Time spent dispatching due tasks in one scheduler loop iteration.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+	})
+
+	inflightExecutions = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "scheduler_inflight_executions",
+		Help: "Task executions currently running or queued behind a running one.",
+	})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Latency of API requests by method, route and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+)
+
+func init() {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "scheduler_worker_queue_depth",
+		Help: "Task executions waiting for a worker.",
+	}, func() float64 {
+		if pool == nil {
+			return 0
+		}
+		return float64(pool.stats().QueueDepth)
+	})
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "scheduler_worker_running",
+		Help: "Workers currently executing a task.",
+	}, func() float64 {
+		if pool == nil {
+			return 0
+		}
+		return float64(pool.stats().Running)
+	})
+	rejections := map[string]func(poolStats) uint64{
+		"queue_full": func(s poolStats) uint64 { return s.RejectedQueueFull },
+		"user_limit": func(s poolStats) uint64 { return s.RejectedUserLimit },
+	}
+	for reason, rejected := range rejections {
+		promauto.NewCounterFunc(prometheus.CounterOpts{
+			Name:        "scheduler_worker_rejections_total",
+			Help:        "Task executions the worker pool refused, by reason.",
+			ConstLabels: prometheus.Labels{"reason": reason},
+		}, func() float64 {
+			if pool == nil {
+				return 0
+			}
+			return float64(rejected(pool.stats()))
+		})
+	}
+}
+
+// observeRun counts a recorded run and, for attempts that sent a request,
+// its duration
+func observeRun(run TaskRun) {
+	if run.Status == runStatusRunning {
+		return
+	}
+	taskExecutions.WithLabelValues(run.Status, run.Trigger).Inc()
+	switch run.Status {
+	case runStatusSuccess, runStatusFailure, runStatusTimeout, runStatusCancelled:
+		taskExecutionDuration.WithLabelValues(run.Status).Observe(float64(run.DurationMs) / 1000)
+	}
+}
+
+// observeRequest records the latency of an API request under its route
+// pattern, keeping the label cardinality bounded. Fiber reuses the memory of
+// its strings, so label values are copied.
+func observeRequest(c *fiber.Ctx, status int, latency time.Duration) {
+	method, route := strings.Clone(c.Method()), strings.Clone(c.Route().Path)
+	httpRequestDuration.WithLabelValues(method, route, strconv.Itoa(status)).Observe(latency.Seconds())
+}
+
+// metricsHandler serves the metrics in the Prometheus text format
+var metricsHandler = adaptor.HTTPHandler(promhttp.Handler())
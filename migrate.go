@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationFiles holds the schema migrations, named <version>_<name>.sql.
+// Migrations only move forward; a released file must never change.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockTimeout is how long startup waits for another process that
+// is migrating the same database
+const migrationLockTimeout = 30 * time.Second
+
+// migration is one embedded schema change
+type migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// migrations returns the embedded migrations ordered by version
+func migrations() ([]migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+	var list []migration
+	seen := make(map[int]string)
+	for _, entry := range entries {
+		version, name, ok := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), "_")
+		n, err := strconv.Atoi(version)
+		if !ok || err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid migration file name %s", entry.Name())
+		}
+		if other, ok := seen[n]; ok {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", other, entry.Name(), n)
+		}
+		seen[n] = entry.Name()
+
+		content, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, migration{Version: n, Name: name, SQL: string(content)})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// latestSchemaVersion is the version of the newest embedded migration
+func latestSchemaVersion() int {
+	list, err := migrations()
+	if err != nil || len(list) == 0 {
+		return 0
+	}
+	return list[len(list)-1].Version
+}
+
+// schemaVersion returns the newest migration applied to the database, 0
+// before any
+func schemaVersion(ctx context.Context, conn *sql.DB) (int, error) {
+	var version sql.NullInt64
+	err := conn.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_migrations").Scan(&version)
+	return int(version.Int64), err
+}
+
+// splitStatements splits a migration into its statements, dropping the
+// parts that only hold comments
+func splitStatements(script string) []string {
+	var statements []string
+	for _, part := range strings.Split(script, ";") {
+		if statementCode(part) != "" {
+			statements = append(statements, strings.TrimSpace(part))
+		}
+	}
+	return statements
+}
+
+// statementCode returns a statement without its comments, on one line
+func statementCode(statement string) string {
+	var code []string
+	for _, line := range strings.Split(statement, "\n") {
+		line, _, _ = strings.Cut(line, "--")
+		if line = strings.TrimSpace(line); line != "" {
+			code = append(code, line)
+		}
+	}
+	return strings.Join(code, " ")
+}
+
+var (
+	createPattern    = regexp.MustCompile(`(?i)^CREATE\s+(?:UNIQUE\s+)?(?:TABLE|INDEX)\s+(\w+)`)
+	addColumnPattern = regexp.MustCompile(`(?i)^ALTER\s+TABLE\s+(\w+)\s+ADD\s+COLUMN\s+(\w+)`)
+)
+
+// legacySchema is the schema of a database created before migrations
+// existed, when each release created its tables and columns at startup
+type legacySchema struct {
+	objects map[string]bool            // Tables and indexes
+	columns map[string]map[string]bool // Columns by table
+}
+
+// readLegacySchema reads the tables, indexes and columns of a database
+// without schema_migrations. It returns nil for a new database.
+func readLegacySchema(ctx context.Context, c *sql.Conn) (*legacySchema, error) {
+	rows, err := c.QueryContext(ctx, "SELECT type, name FROM sqlite_master WHERE type IN ('table', 'index')")
+	if err != nil {
+		return nil, err
+	}
+	schema := &legacySchema{objects: make(map[string]bool), columns: make(map[string]map[string]bool)}
+	var tables []string
+	for rows.Next() {
+		var kind, name string
+		if err := rows.Scan(&kind, &name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		schema.objects[name] = true
+		if kind == "table" {
+			tables = append(tables, name)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if schema.objects["schema_migrations"] || !schema.objects["users"] && !schema.objects["tasks"] {
+		return nil, nil
+	}
+
+	for _, table := range tables {
+		schema.columns[table] = make(map[string]bool)
+		rows, err := c.QueryContext(ctx, "SELECT name FROM pragma_table_info(?)", table)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var column string
+			if err := rows.Scan(&column); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			schema.columns[table][column] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return schema, nil
+}
+
+// has reports whether the statement creates a table, index or column the
+// database already has
+func (s *legacySchema) has(statement string) bool {
+	code := statementCode(statement)
+	if match := createPattern.FindStringSubmatch(code); match != nil {
+		return s.objects[match[1]]
+	}
+	if match := addColumnPattern.FindStringSubmatch(code); match != nil {
+		return s.columns[match[1]][match[2]]
+	}
+	return false
+}
+
+// migrateDatabase applies the pending migrations in order and returns them.
+// The run holds the database write lock from reading schema_migrations to
+// commit, so concurrent servers migrate one after the other and the schema
+// never ends up half-migrated. A dry run applies the migrations and rolls
+// them back, which checks them against the actual database.
+func migrateDatabase(ctx context.Context, conn *sql.DB, dryRun bool) ([]migration, error) {
+	list, err := migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := conn.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	if _, err := c.ExecContext(ctx, fmt.Sprintf("PRAGMA busy_timeout = %d", migrationLockTimeout.Milliseconds())); err != nil {
+		return nil, err
+	}
+	// BEGIN IMMEDIATE takes the write lock up front
+	if _, err := c.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return nil, fmt.Errorf("locking database for migrations: %w", err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			c.ExecContext(context.Background(), "ROLLBACK")
+		}
+	}()
+
+	// A database from before migrations is adopted on its first run: the
+	// statements creating what its release already created are skipped.
+	// Every later run applies the migrations strictly.
+	legacy, err := readLegacySchema(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	_, err = c.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+        version INTEGER PRIMARY KEY,
+        name TEXT,
+        applied_at INTEGER
+    )`)
+	if err != nil {
+		return nil, err
+	}
+	var current sql.NullInt64
+	if err := c.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_migrations").Scan(&current); err != nil {
+		return nil, err
+	}
+	if latest := list[len(list)-1].Version; int(current.Int64) > latest {
+		return nil, fmt.Errorf("database schema version %d is newer than this build's %d", current.Int64, latest)
+	}
+
+	var pending []migration
+	for _, m := range list {
+		if m.Version <= int(current.Int64) {
+			continue
+		}
+		for _, statement := range splitStatements(m.SQL) {
+			if legacy != nil && legacy.has(statement) {
+				continue
+			}
+			if _, err := c.ExecContext(ctx, statement); err != nil {
+				return nil, fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+			}
+		}
+		_, err := c.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+			m.Version, m.Name, time.Now().Unix())
+		if err != nil {
+			return nil, err
+		}
+		pending = append(pending, m)
+	}
+
+	if dryRun {
+		return pending, nil // Rolled back by the deferred ROLLBACK
+	}
+	if _, err := c.ExecContext(ctx, "COMMIT"); err != nil {
+		return nil, err
+	}
+	committed = true
+	return pending, nil
+}
+
+// runMigrate implements the "migrate" command, which migrates the database
+// without starting the server. With -dry-run it lists the pending
+// migrations and leaves the database untouched.
+func runMigrate(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "check and list the pending migrations without applying them")
+	configPath := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML config file naming the database")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	config, err := readConfig(*configPath)
+	if err == nil {
+		err = config.validate()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:", err)
+		return 2
+	}
+	InitializeLogger(config.Log)
+	openDatabase(config.Database)
+	defer db.Close()
+
+	applied, err := migrateDatabase(context.Background(), db, *dryRun)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error migrating database:", err)
+		return 1
+	}
+	verb := "Applied"
+	if *dryRun {
+		verb = "Would apply"
+	}
+	for _, m := range applied {
+		fmt.Printf("%s migration %d_%s\n", verb, m.Version, m.Name)
+	}
+	if len(applied) == 0 {
+		fmt.Println("Database schema is up to date")
+	}
+	if !*dryRun {
+		if _, err := migrateTokenHashes(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error hashing user tokens:", err)
+			return 1
+		}
+	}
+	return 0
+}
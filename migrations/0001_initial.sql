@@ -0,0 +1,21 @@
+-- Users and tasks as created by the first release
+CREATE TABLE users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username TEXT UNIQUE,
+    token TEXT
+);
+
+CREATE TABLE tasks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER,
+    name TEXT,
+    message TEXT,
+    url TEXT,
+    interval INTEGER,
+    start INTEGER,
+    end INTEGER,
+    is_recurring BOOLEAN,
+    enabled BOOLEAN DEFAULT FALSE,  -- Default value for Enabled
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    UNIQUE(user_id, name)  -- Ensure task name is unique per user
+);
@@ -0,0 +1,3 @@
+-- Cron schedules evaluated in a time zone
+ALTER TABLE tasks ADD COLUMN schedule TEXT DEFAULT '';  -- Cron expression, empty for interval tasks
+ALTER TABLE tasks ADD COLUMN timezone TEXT DEFAULT 'UTC';  -- IANA zone the schedule is evaluated in
@@ -0,0 +1,16 @@
+-- Execution history
+CREATE TABLE task_runs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    task_id INTEGER,
+    user_id INTEGER,  -- Kept so history survives deletion of the task
+    scheduled_at INTEGER,
+    started_at INTEGER,
+    duration_ms INTEGER,
+    status_code INTEGER,
+    status TEXT,
+    response_body TEXT,
+    error TEXT,
+    attempt INTEGER
+);
+
+CREATE INDEX idx_task_runs_task ON task_runs(task_id, started_at);
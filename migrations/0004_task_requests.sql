@@ -0,0 +1,7 @@
+-- Retries and configurable requests
+ALTER TABLE tasks ADD COLUMN retry_policy TEXT DEFAULT '{}';  -- RetryPolicy encoded as JSON
+ALTER TABLE tasks ADD COLUMN method TEXT DEFAULT 'GET';
+ALTER TABLE tasks ADD COLUMN headers TEXT DEFAULT '{}';  -- Request headers encoded as JSON
+ALTER TABLE tasks ADD COLUMN query TEXT DEFAULT '{}';  -- Query parameters encoded as JSON
+ALTER TABLE tasks ADD COLUMN body TEXT DEFAULT '';  -- Request body template
+ALTER TABLE tasks ADD COLUMN timeout INTEGER DEFAULT 0;  -- Request timeout in seconds, 0 for the default
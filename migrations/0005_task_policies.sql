@@ -0,0 +1,5 @@
+-- Overlap and misfire policies
+ALTER TABLE tasks ADD COLUMN overlap TEXT DEFAULT 'skip';  -- Policy for executions that overlap a running one
+ALTER TABLE tasks ADD COLUMN misfire TEXT DEFAULT 'fire_once';  -- Policy for occurrences missed while late
+ALTER TABLE tasks ADD COLUMN misfire_threshold INTEGER DEFAULT 0;  -- Seconds late before misfiring, 0 for the default
+ALTER TABLE tasks ADD COLUMN misfire_limit INTEGER DEFAULT 0;  -- Most missed occurrences fired, 0 for the default
@@ -0,0 +1,7 @@
+-- Hashed tokens and admin accounts. Plaintext tokens are hashed by
+-- migrateTokenHashes once the schema is up to date.
+ALTER TABLE users ADD COLUMN token_prefix TEXT;  -- Leading characters of the token, used for lookup
+ALTER TABLE users ADD COLUMN token_hash TEXT;  -- Salted SHA-256 hash of the token
+ALTER TABLE users ADD COLUMN is_admin BOOLEAN DEFAULT FALSE;
+
+CREATE INDEX idx_users_token_prefix ON users(token_prefix);
@@ -0,0 +1,17 @@
+-- Scoped API keys
+CREATE TABLE api_keys (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER,
+    name TEXT,
+    key_prefix TEXT,  -- Leading characters of the key, used for lookup
+    key_hash TEXT,  -- Salted SHA-256 hash of the key
+    scopes TEXT,  -- Comma-separated scopes
+    created_at INTEGER,
+    expires_at INTEGER DEFAULT 0,  -- 0 for keys that never expire
+    last_used_at INTEGER DEFAULT 0,
+    revoked_at INTEGER DEFAULT 0,  -- 0 while the key is active
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    UNIQUE(user_id, name)
+);
+
+CREATE INDEX idx_api_keys_prefix ON api_keys(key_prefix);
@@ -0,0 +1,17 @@
+-- Teams sharing tasks
+CREATE TABLE teams (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT UNIQUE,
+    created_at INTEGER
+);
+
+CREATE TABLE team_members (
+    team_id INTEGER,
+    user_id INTEGER,
+    role TEXT,  -- owner, editor or viewer
+    FOREIGN KEY (team_id) REFERENCES teams(id),
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    PRIMARY KEY (team_id, user_id)
+);
+
+ALTER TABLE tasks ADD COLUMN team_id INTEGER DEFAULT 0;  -- Owning team, 0 for a personal task
@@ -0,0 +1,2 @@
+-- Manual runs
+ALTER TABLE task_runs ADD COLUMN trigger TEXT DEFAULT 'schedule';  -- schedule or manual
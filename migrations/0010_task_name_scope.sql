@@ -0,0 +1,45 @@
+-- Task names are unique within a team, or per user for personal tasks.
+-- SQLite cannot drop the UNIQUE(user_id, name) constraint, so the table is
+-- rebuilt. Team tasks of different creators sharing a name are renamed.
+UPDATE tasks SET name = name || ' (' || id || ')'
+WHERE team_id != 0 AND id NOT IN (SELECT MIN(id) FROM tasks WHERE team_id != 0 GROUP BY team_id, name);
+
+CREATE TABLE tasks_scoped (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER,  -- Creator of the task
+    name TEXT,
+    message TEXT,
+    url TEXT,
+    interval INTEGER,
+    start INTEGER,
+    end INTEGER,
+    is_recurring BOOLEAN,
+    enabled BOOLEAN DEFAULT FALSE,
+    schedule TEXT DEFAULT '',
+    timezone TEXT DEFAULT 'UTC',
+    retry_policy TEXT DEFAULT '{}',
+    method TEXT DEFAULT 'GET',
+    headers TEXT DEFAULT '{}',
+    query TEXT DEFAULT '{}',
+    body TEXT DEFAULT '',
+    timeout INTEGER DEFAULT 0,
+    overlap TEXT DEFAULT 'skip',
+    misfire TEXT DEFAULT 'fire_once',
+    misfire_threshold INTEGER DEFAULT 0,
+    misfire_limit INTEGER DEFAULT 0,
+    team_id INTEGER DEFAULT 0,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+INSERT INTO tasks_scoped (id, user_id, name, message, url, interval, start, end, is_recurring, enabled, schedule, timezone, retry_policy, method, headers, query, body, timeout, overlap, misfire, misfire_threshold, misfire_limit, team_id)
+SELECT id, user_id, name, message, url, interval, start, end, is_recurring, enabled, schedule, timezone, retry_policy, method, headers, query, body, timeout, overlap, misfire, misfire_threshold, misfire_limit, team_id FROM tasks;
+
+-- Keeps IDs of deleted tasks from being reused, their runs stay apart
+DELETE FROM sqlite_sequence WHERE name = 'tasks_scoped';
+INSERT INTO sqlite_sequence (name, seq) SELECT 'tasks_scoped', seq FROM sqlite_sequence WHERE name = 'tasks';
+
+DROP TABLE tasks;
+ALTER TABLE tasks_scoped RENAME TO tasks;
+
+CREATE UNIQUE INDEX idx_tasks_team_name ON tasks(team_id, name) WHERE team_id != 0;
+CREATE UNIQUE INDEX idx_tasks_user_name ON tasks(user_id, name) WHERE team_id = 0;
@@ -0,0 +1,4 @@
+-- Revoke the token "123" the first release hardcoded for the user "a",
+-- whether still in plaintext or already hashed: a prefix that short is the
+-- whole token. Applied once, so accounts later configured with it are kept.
+UPDATE users SET token = '', token_prefix = '', token_hash = '' WHERE token = '123' OR token_prefix = '123';
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Misfire policies decide how a task catches up when it is picked up later
+// than its misfire threshold, e.g. after downtime or a stalled scheduler
+const (
+	misfireFireOnce = "fire_once" // Run once for all missed occurrences
+	misfireFireAll  = "fire_all"  // Run every missed occurrence, up to the misfire limit
+	misfireSkip     = "skip"      // Run nothing and wait for the next occurrence; one-shot tasks still run once
+)
+
+// Defaults and bounds for misfire settings
+const (
+	defaultMisfireThreshold = 60 // Seconds
+	defaultMisfireLimit     = 10
+	maxMisfireLimit         = 100
+	maxMissedScan           = 100000 // Occurrences inspected when counting missed runs
+)
+
+// validateMisfire checks a task's misfire settings, defaulting the policy
+// to fire_once
+func validateMisfire(task *Task) validationErrors {
+	var errs validationErrors
+	switch task.Misfire {
+	case "":
+		task.Misfire = misfireFireOnce
+	case misfireFireOnce, misfireFireAll, misfireSkip:
+	default:
+		errs.add("misfire", "unknown misfire policy %q", task.Misfire)
+	}
+	if task.MisfireThreshold < 0 {
+		errs.add("misfire_threshold", "must not be negative")
+	}
+	if task.MisfireLimit < 0 || task.MisfireLimit > maxMisfireLimit {
+		errs.add("misfire_limit", "must be between 0 and %d", maxMisfireLimit)
+	}
+	return errs
+}
+
+// missedStarts returns the number of occurrences of a task between its start
+// time and now, and the most recent of them, at most limit
+func missedStarts(task Task, now time.Time, limit int) (int, []int64) {
+	if !task.IsRecurring {
+		return 1, []int64{task.Start}
+	}
+
+	nextAfter, err := nextStartFunc(task)
+	if err != nil {
+		return 1, []int64{task.Start}
+	}
+
+	var total int
+	var starts []int64
+	for at := task.Start; at <= now.Unix() && total < maxMissedScan; total++ {
+		starts = append(starts, at)
+		if len(starts) > limit {
+			starts = starts[1:]
+		}
+		next := nextAfter(time.Unix(at, 0))
+		if next <= at {
+			total++
+			break
+		}
+		at = next
+	}
+	return total, starts
+}
+
+// planOccurrences returns the scheduled start times to execute for a task
+// picked up at now. When the task is later than its misfire threshold, the
+// misfire policy decides and the returned note describes the decision.
+func planOccurrences(task Task, now time.Time) ([]int64, string) {
+	threshold := task.MisfireThreshold
+	if threshold <= 0 {
+		threshold = defaultMisfireThreshold
+	}
+	late := now.Unix() - task.Start
+	if late <= threshold {
+		return []int64{task.Start}, ""
+	}
+
+	limit := task.MisfireLimit
+	if limit <= 0 {
+		limit = defaultMisfireLimit
+	}
+	total, starts := missedStarts(task, now, limit)
+	summary := fmt.Sprintf("misfire: %d occurrence(s) missed, late by %ds", total, late)
+
+	switch {
+	case task.Misfire == misfireSkip && task.IsRecurring:
+		return nil, summary + ", skipped to the next occurrence"
+	case task.Misfire == misfireFireAll:
+		return starts, fmt.Sprintf("%s, firing the last %d", summary, len(starts))
+	default:
+		return starts[len(starts)-1:], summary + ", firing once"
+	}
+}
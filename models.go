@@ -5,18 +5,103 @@ type User struct {
 	ID       int    `json:"id"`
 	Username string `json:"username"`
 	Token    string `json:"token"`
+	IsAdmin  bool   `json:"is_admin"` // Owns every task and team
+}
+
+// APIKey is a named, scoped credential of a user. Only its prefix is
+// returned after creation.
+type APIKey struct {
+	ID         int      `json:"id"`
+	UserID     int      `json:"user_id"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`       // Leading characters of the key
+	Scopes     []string `json:"scopes"`       // e.g. tasks:read, tasks:write, admin
+	CreatedAt  int64    `json:"created_at"`   // Unix timestamp
+	ExpiresAt  int64    `json:"expires_at"`   // Unix timestamp, 0 for no expiry
+	LastUsedAt int64    `json:"last_used_at"` // Unix timestamp, 0 if never used
+	RevokedAt  int64    `json:"revoked_at"`   // Unix timestamp, 0 while active
+}
+
+// Team groups users who share tasks. Role is the requesting user's role.
+type Team struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	CreatedAt int64  `json:"created_at"` // Unix timestamp
+	Role      string `json:"role,omitempty"`
+}
+
+// TeamMember is a user's membership in a team.
+type TeamMember struct {
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+	Role     string `json:"role"` // owner, editor or viewer
 }
 
 // Task represents a scheduled task.
 type Task struct {
-	ID          int    `json:"id"`
-	UserID      int    `json:"user_id"`
-	Name        string `json:"name"` // Unique task name per user
-	Message     string `json:"message"`
-	URL         string `json:"url"`
-	Interval    int64  `json:"interval"`     // Interval in seconds
-	Start       int64  `json:"start"`        // Start time (Unix timestamp)
-	End         int64  `json:"end"`          // End time (Unix timestamp)
-	IsRecurring bool   `json:"is_recurring"` // Indicates if the task is recurring
-	Enabled     bool   `json:"enabled"`      // Indicates if the task is enabled
+	ID               int         `json:"id"`
+	UserID           int         `json:"user_id"`
+	Name             string      `json:"name"` // Unique within the team, or per user for personal tasks
+	Message          string      `json:"message"`
+	URL              string      `json:"url"`
+	Interval         int64       `json:"interval"`          // Interval in seconds
+	Start            int64       `json:"start"`             // Start time (Unix timestamp)
+	End              int64       `json:"end"`               // End time (Unix timestamp)
+	IsRecurring      bool        `json:"is_recurring"`      // Indicates if the task is recurring
+	Enabled          bool        `json:"enabled"`           // Indicates if the task is enabled
+	Schedule         string      `json:"schedule"`          // Cron expression; overrides Interval when set
+	Timezone         string      `json:"timezone"`          // IANA time zone used to evaluate Schedule
+	Retry            RetryPolicy `json:"retry"`             // Retry behaviour for failed executions
+	Method           string      `json:"method"`            // HTTP method, GET by default
+	Headers          StringMap   `json:"headers"`           // Request headers, e.g. Authorization
+	Query            StringMap   `json:"query"`             // Query parameters added to URL
+	Body             string      `json:"body"`              // Request body template (text/template)
+	Timeout          int64       `json:"timeout"`           // Request timeout in seconds; 0 uses the default
+	Overlap          string      `json:"overlap"`           // Overlap policy: skip, queue, allow or cancel_previous
+	Misfire          string      `json:"misfire"`           // Misfire policy: fire_once, fire_all or skip
+	MisfireThreshold int64       `json:"misfire_threshold"` // Seconds a run may be late before it misfires
+	MisfireLimit     int         `json:"misfire_limit"`     // Most missed occurrences fired by fire_all
+	TeamID           int         `json:"team_id"`           // Owning team, 0 for a personal task
+}
+
+// StringMap is a string map stored as JSON in a single column.
+type StringMap map[string]string
+
+// RetryPolicy describes how failed executions of a task are retried.
+// Zero values fall back to the defaults applied by withDefaults.
+type RetryPolicy struct {
+	MaxAttempts    int     `json:"max_attempts"`           // Total attempts per run; 0 or 1 disables retries
+	InitialDelayMs int64   `json:"initial_delay_ms"`       // Delay before the first retry in milliseconds
+	Multiplier     float64 `json:"multiplier"`             // Factor applied to the delay after each retry
+	MaxDelayMs     int64   `json:"max_delay_ms"`           // Upper bound for a single delay in milliseconds
+	Jitter         float64 `json:"jitter"`                 // Random spread applied to each delay, from 0 to 1
+	RetryableCodes []int   `json:"retryable_status_codes"` // HTTP statuses worth retrying; defaults to 408, 429 and 5xx
+}
+
+// TaskView is a Task as returned by the API, with its start and end times
+// rendered both in UTC and in the task's own time zone.
+type TaskView struct {
+	Task
+	StartUTC   string `json:"start_utc"`
+	StartLocal string `json:"start_local"`
+	EndUTC     string `json:"end_utc,omitempty"`
+	EndLocal   string `json:"end_local,omitempty"`
+}
+
+// TaskRun records a single execution attempt of a task.
+type TaskRun struct {
+	ID           int    `json:"id"`
+	TaskID       int    `json:"task_id"`
+	UserID       int    `json:"user_id"`
+	ScheduledAt  int64  `json:"scheduled_at"`  // Planned start time (Unix timestamp)
+	StartedAt    int64  `json:"started_at"`    // Actual start time (Unix timestamp)
+	DurationMs   int64  `json:"duration_ms"`   // Duration of the attempt in milliseconds
+	StatusCode   int    `json:"status_code"`   // HTTP status, 0 when no response was received
+	Status       string `json:"status"`        // Outcome of the run, e.g. success or failure
+	ResponseBody string `json:"response_body"` // Response body, truncated
+	Error        string `json:"error"`         // Error message for failed requests
+	Attempt      int    `json:"attempt"`       // Attempt number, starting at 1
+	Trigger      string `json:"trigger"`       // What started the run: schedule or manual
+
+	permanent bool // The request could not be built, so retrying cannot help
 }
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// apiOperation documents one route in the OpenAPI document
+type apiOperation struct {
+	method     string
+	path       string // Fiber route path, e.g. /api/v1/tasks/:id
+	summary    string
+	scope      string      // Required scope, "" for public routes
+	request    interface{} // Request body schema, nil for none
+	response   interface{} // Success response schema
+	status     string      // Success status, 200 when empty
+	media      string      // Response media type, JSON when empty
+	query      []string    // Query parameters
+	validated  bool        // Invalid fields are answered with 422 and their errors
+	pooled     bool        // Runs through the worker pool, which may refuse with 409, 429 or 503
+	replacedBy []string    // v1 operations replacing a legacy route, e.g. "GET /api/v1/tasks"; the route is deprecated
+}
+
+// schemaTypes are the models exposed as named OpenAPI schemas
+var schemaTypes = []interface{}{User{}, Task{}, TaskView{}, TaskRun{}, RetryPolicy{}, APIKey{}, Team{}, TeamMember{}}
+
+// ref points to a named schema
+func ref(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+// object builds an object schema from name/schema pairs
+func object(pairs ...interface{}) map[string]interface{} {
+	properties := map[string]interface{}{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		properties[pairs[i].(string)] = pairs[i+1]
+	}
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+// arrayOf builds an array schema
+func arrayOf(items interface{}) map[string]interface{} {
+	return map[string]interface{}{"type": "array", "items": items}
+}
+
+func primitive(kind string) map[string]interface{} {
+	return map[string]interface{}{"type": kind}
+}
+
+var (
+	integerSchema = primitive("integer")
+	stringSchema  = primitive("string")
+	booleanSchema = primitive("boolean")
+	messageSchema = object("message", stringSchema)
+)
+
+// schemaOf derives a schema from a Go type using its json tags. Named
+// structs listed in schemaTypes become references.
+func schemaOf(t reflect.Type, root bool) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Bool:
+		return primitive("boolean")
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return primitive("integer")
+	case reflect.Float32, reflect.Float64:
+		return primitive("number")
+	case reflect.String:
+		return primitive("string")
+	case reflect.Slice:
+		return arrayOf(schemaOf(t.Elem(), false))
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), false)}
+	case reflect.Struct:
+		if !root {
+			return ref(t.Name())
+		}
+		properties := map[string]interface{}{}
+		addProperties(t, properties)
+		return map[string]interface{}{"type": "object", "properties": properties}
+	}
+	return map[string]interface{}{}
+}
+
+// addProperties adds the json fields of a struct, flattening embedded ones
+func addProperties(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous {
+			addProperties(field.Type, properties)
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		properties[name] = schemaOf(field.Type, false)
+	}
+}
+
+// apiOperations lists every route served by newApp
+func apiOperations() []apiOperation {
+	task := ref("Task")
+	taskView := ref("TaskView")
+	scheduled := object("message", stringSchema, "task", map[string]interface{}{
+		"allOf": []interface{}{task, object("task_id", integerSchema)},
+	})
+	enabled := object("message", stringSchema, "task", object("task_id", integerSchema, "enabled", booleanSchema))
+	runs := object("runs", arrayOf(ref("TaskRun")), "total", integerSchema, "limit", integerSchema, "offset", integerSchema)
+	registered := object("message", stringSchema, "token", stringSchema)
+	login := object("message", stringSchema, "user_id", integerSchema, "tasks", arrayOf(task))
+	tasks := object("tasks", arrayOf(taskView), "total", integerSchema, "limit", integerSchema, "next_cursor", stringSchema)
+	tasksQuery := []string{"limit", "cursor", "sort", "enabled", "recurring", "name_prefix", "url_host", "next_run_before", "next_run_after"}
+	updated := object("message", stringSchema, "task", taskView)
+	keyRequest := object("name", stringSchema, "scopes", arrayOf(stringSchema), "expires_at", integerSchema)
+	keyCreated := object("message", stringSchema, "key", stringSchema, "api_key", ref("APIKey"))
+	keys := object("keys", arrayOf(ref("APIKey")))
+	teamCreated := object("message", stringSchema, "team", ref("Team"))
+	teams := object("teams", arrayOf(ref("Team")))
+	members := object("members", arrayOf(ref("TeamMember")))
+	memberRequest := object("username", stringSchema, "role", stringSchema)
+	memberSet := object("message", stringSchema, "member", ref("TeamMember"))
+	runsQuery := []string{"limit", "offset", "status"}
+	ran := object("message", stringSchema, "status", stringSchema, "runs", arrayOf(ref("TaskRun")), "run_id", integerSchema)
+
+	return []apiOperation{
+		{method: "GET", path: "/openapi.json", summary: "This OpenAPI document", response: primitive("object")},
+		{method: "GET", path: "/", summary: "The web interface", response: stringSchema, media: "text/html"},
+		{method: "GET", path: "/metrics", summary: "Metrics in the Prometheus text format", response: stringSchema, media: "text/plain"},
+		{method: "GET", path: "/healthz", summary: "Liveness: the process is up", response: object("status", stringSchema, "uptime_seconds", integerSchema)},
+		{method: "GET", path: "/readyz", summary: "Readiness: database, schema, scheduler and worker queue checks; 503 when any fails", response: object("status", stringSchema, "checks", map[string]interface{}{"type": "object", "additionalProperties": object("status", stringSchema, "error", stringSchema, "detail", primitive("object"))})},
+
+		{method: "POST", path: "/register", summary: "Register a user", request: object("username", stringSchema), response: registered, replacedBy: []string{"POST /api/v1/users"}},
+		{method: "POST", path: "/login", summary: "Check credentials and list the user's tasks", request: ref("User"), response: login, replacedBy: []string{"POST /api/v1/login"}},
+		{method: "POST", path: "/schedule", summary: "Schedule a task", scope: scopeTasksWrite, request: task, response: scheduled, validated: true, replacedBy: []string{"POST /api/v1/tasks"}},
+		{method: "DELETE", path: "/api/tasks/delete", summary: "Delete a task", scope: scopeTasksWrite, request: object("task_id", integerSchema), response: messageSchema, replacedBy: []string{"DELETE /api/v1/tasks/:id"}},
+		{method: "POST", path: "/api/tasks/set-enabled", summary: "Enable or disable a task", scope: scopeTasksWrite, request: object("task_id", integerSchema, "enabled", booleanSchema), response: enabled, replacedBy: []string{"POST /api/v1/tasks/:id\\:enable", "POST /api/v1/tasks/:id\\:disable"}},
+		{method: "POST", path: "/api/tasks", summary: "List tasks", scope: scopeTasksRead, response: tasks, query: tasksQuery, replacedBy: []string{"GET /api/v1/tasks"}},
+		{method: "PATCH", path: "/api/tasks/:id", summary: "Update some fields of a task", scope: scopeTasksWrite, request: task, response: updated, validated: true, replacedBy: []string{"PATCH /api/v1/tasks/:id"}},
+		{method: "GET", path: "/api/tasks/:id/runs", summary: "List the runs of a task", scope: scopeTasksRead, response: runs, query: runsQuery, replacedBy: []string{"GET /api/v1/tasks/:id/runs"}},
+		{method: "GET", path: "/api/teams", summary: "List the user's teams", scope: scopeTasksRead, response: teams, replacedBy: []string{"GET /api/v1/teams"}},
+		{method: "POST", path: "/api/teams", summary: "Create a team", scope: scopeTasksWrite, request: object("name", stringSchema), response: teamCreated, replacedBy: []string{"POST /api/v1/teams"}},
+		{method: "GET", path: "/api/teams/:id/members", summary: "List team members", scope: scopeTasksRead, response: members, replacedBy: []string{"GET /api/v1/teams/:id/members"}},
+		{method: "POST", path: "/api/teams/:id/members", summary: "Add a team member or change their role", scope: scopeAdmin, request: memberRequest, response: memberSet, replacedBy: []string{"PUT /api/v1/teams/:id/members"}},
+		{method: "DELETE", path: "/api/teams/:id/members/:user_id", summary: "Remove a team member", scope: scopeAdmin, response: messageSchema, replacedBy: []string{"DELETE /api/v1/teams/:id/members/:user_id"}},
+		{method: "GET", path: "/api/keys", summary: "List API keys", scope: scopeAdmin, response: keys, replacedBy: []string{"GET /api/v1/keys"}},
+		{method: "POST", path: "/api/keys", summary: "Create an API key", scope: scopeAdmin, request: keyRequest, response: keyCreated, replacedBy: []string{"POST /api/v1/keys"}},
+		{method: "DELETE", path: "/api/keys/:id", summary: "Revoke an API key", scope: scopeAdmin, response: messageSchema, replacedBy: []string{"DELETE /api/v1/keys/:id"}},
+
+		{method: "POST", path: "/api/v1/users", summary: "Register a user", request: object("username", stringSchema), response: registered, status: "201"},
+		{method: "POST", path: "/api/v1/login", summary: "Check credentials and list the user's tasks", request: ref("User"), response: login},
+		{method: "GET", path: "/api/v1/tasks", summary: "List tasks", scope: scopeTasksRead, response: tasks, query: tasksQuery},
+		{method: "POST", path: "/api/v1/tasks", summary: "Create a task", scope: scopeTasksWrite, request: task, response: scheduled, status: "201", validated: true},
+		{method: "GET", path: "/api/v1/tasks/:id", summary: "Get a task", scope: scopeTasksRead, response: object("task", taskView)},
+		{method: "PATCH", path: "/api/v1/tasks/:id", summary: "Update some fields of a task", scope: scopeTasksWrite, request: task, response: updated, validated: true},
+		{method: "DELETE", path: "/api/v1/tasks/:id", summary: "Delete a task", scope: scopeTasksWrite, status: "204"},
+		{method: "POST", path: "/api/v1/tasks/:id\\:enable", summary: "Enable a task", scope: scopeTasksWrite, response: enabled},
+		{method: "POST", path: "/api/v1/tasks/:id\\:disable", summary: "Disable a task", scope: scopeTasksWrite, response: enabled},
+		{method: "POST", path: "/api/v1/tasks/:id\\:run", summary: "Run a task now in a single attempt; async=true returns the run ID and retries per the policy", scope: scopeTasksWrite, response: ran, query: []string{"async"}, pooled: true},
+		{method: "GET", path: "/api/v1/tasks/:id/runs", summary: "List the runs of a task", scope: scopeTasksRead, response: runs, query: runsQuery},
+		{method: "GET", path: "/api/v1/keys", summary: "List API keys", scope: scopeAdmin, response: keys},
+		{method: "POST", path: "/api/v1/keys", summary: "Create an API key", scope: scopeAdmin, request: keyRequest, response: keyCreated, status: "201"},
+		{method: "DELETE", path: "/api/v1/keys/:id", summary: "Revoke an API key", scope: scopeAdmin, response: messageSchema},
+		{method: "GET", path: "/api/v1/teams", summary: "List the user's teams", scope: scopeTasksRead, response: teams},
+		{method: "POST", path: "/api/v1/teams", summary: "Create a team", scope: scopeTasksWrite, request: object("name", stringSchema), response: teamCreated, status: "201"},
+		{method: "GET", path: "/api/v1/teams/:id/members", summary: "List team members", scope: scopeTasksRead, response: members},
+		{method: "PUT", path: "/api/v1/teams/:id/members", summary: "Add a team member or change their role", scope: scopeAdmin, request: memberRequest, response: memberSet},
+		{method: "DELETE", path: "/api/v1/teams/:id/members/:user_id", summary: "Remove a team member", scope: scopeAdmin, response: messageSchema},
+	}
+}
+
+var routeParam = regexp.MustCompile(`:(\w+)`)
+
+// openAPIPath converts a Fiber route path to an OpenAPI path and its
+// parameters, e.g. /tasks/:id\:run to /tasks/{id}:run.
+func openAPIPath(path string) (string, []string) {
+	var params []string
+	parts := strings.Split(path, "\\:")
+	for i, part := range parts {
+		parts[i] = routeParam.ReplaceAllStringFunc(part, func(m string) string {
+			params = append(params, m[1:])
+			return "{" + m[1:] + "}"
+		})
+	}
+	return strings.Join(parts, ":"), params
+}
+
+// buildOpenAPI assembles the OpenAPI 3 document of the API
+func buildOpenAPI() map[string]interface{} {
+	schemas := map[string]interface{}{
+		"Error":         object("error", stringSchema, "fields", arrayOf(ref("FieldError"))),
+		"ErrorEnvelope": object("error", object("code", stringSchema, "message", stringSchema, "fields", arrayOf(ref("FieldError")))),
+		"FieldError":    object("field", stringSchema, "message", stringSchema),
+	}
+	for _, model := range schemaTypes {
+		t := reflect.TypeOf(model)
+		schemas[t.Name()] = schemaOf(t, true)
+	}
+
+	paths := map[string]interface{}{}
+	for _, op := range apiOperations() {
+		path, params := openAPIPath(op.path)
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			item = map[string]interface{}{}
+			paths[path] = item
+		}
+
+		errorSchema := ref("Error")
+		if strings.HasPrefix(op.path, "/api/v1/") {
+			errorSchema = ref("ErrorEnvelope")
+		}
+		status := op.status
+		if status == "" {
+			status = "200"
+		}
+		success := map[string]interface{}{"description": "Success"}
+		if op.media != "" {
+			success["content"] = map[string]interface{}{op.media: map[string]interface{}{"schema": op.response}}
+		} else if op.response != nil {
+			success["content"] = jsonContent(op.response)
+		}
+		responses := map[string]interface{}{
+			status:    success,
+			"default": map[string]interface{}{"description": "Error", "content": jsonContent(errorSchema)},
+		}
+		if op.validated {
+			responses["400"] = map[string]interface{}{"description": "Malformed request body", "content": jsonContent(errorSchema)}
+			responses["422"] = map[string]interface{}{"description": "Validation failed; fields lists every invalid field", "content": jsonContent(errorSchema)}
+		}
+		if op.pooled {
+			responses["409"] = map[string]interface{}{"description": "A manual run of the task is already queued or running", "content": jsonContent(errorSchema)}
+			responses["429"] = map[string]interface{}{"description": "The worker queue or the user's execution limit is full", "content": jsonContent(errorSchema)}
+			responses["503"] = map[string]interface{}{"description": "The server is shutting down", "content": jsonContent(errorSchema)}
+		}
+		operation := map[string]interface{}{
+			"summary":   op.summary,
+			"responses": responses,
+		}
+
+		var parameters []interface{}
+		for _, name := range params {
+			parameters = append(parameters, map[string]interface{}{"name": name, "in": "path", "required": true, "schema": integerSchema})
+		}
+		for _, name := range op.query {
+			parameters = append(parameters, map[string]interface{}{"name": name, "in": "query", "schema": stringSchema})
+		}
+		if parameters != nil {
+			operation["parameters"] = parameters
+		}
+		if op.request != nil {
+			operation["requestBody"] = map[string]interface{}{"required": true, "content": jsonContent(op.request)}
+		}
+		if op.scope != "" {
+			operation["security"] = []interface{}{map[string]interface{}{"bearerAuth": []string{}}}
+			operation["x-required-scope"] = op.scope
+		}
+		if op.replacedBy != nil {
+			var replacements []string
+			for _, replacement := range op.replacedBy {
+				method, path, _ := strings.Cut(replacement, " ")
+				path, _ = openAPIPath(path)
+				replacements = append(replacements, method+" "+path)
+			}
+			operation["deprecated"] = true
+			operation["description"] = "Deprecated, use " + strings.Join(replacements, " or ") + "."
+		}
+		item[strings.ToLower(op.method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "ManagerSchdule API",
+			"version":     "1.0.0",
+			"description": "Schedules HTTP requests. Authenticate with a user token or an API key as a bearer token.",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+func jsonContent(schema interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+// openAPIDocument is built once at startup
+var openAPIDocument = buildOpenAPI()
+
+// openAPIHandler serves the OpenAPI document
+func openAPIHandler(c *fiber.Ctx) error {
+	return c.JSON(openAPIDocument)
+}
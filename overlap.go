@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Overlap policies decide what happens when a task comes due again while a
+// previous execution is still running
+const (
+	overlapSkip           = "skip"            // Drop the new occurrence and record it as skipped
+	overlapQueue          = "queue"           // Run the new occurrence once the previous one finishes
+	overlapAllow          = "allow"           // Run both concurrently
+	overlapCancelPrevious = "cancel_previous" // Cancel the running execution and start the new one
+)
+
+// maxQueuedExecutions bounds how many occurrences of a task may wait behind a
+// running one under the queue policy; further occurrences are skipped
+const maxQueuedExecutions = 1
+
+// errAlreadyRunning is returned when the same occurrence is already executing
+var errAlreadyRunning = errors.New("task occurrence is already running")
+
+// execution is an in-flight (or queued) execution of a task occurrence
+type execution struct {
+	taskID int
+	start  int64 // Scheduled start of the occurrence being executed
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// inflight tracks the executions of each task that have not finished yet
+var inflight = struct {
+	sync.Mutex
+	byTask map[int][]*execution
+}{byTask: make(map[int][]*execution)}
+
+// validateOverlap checks a task's overlap policy, defaulting it to skip
+func validateOverlap(task *Task) error {
+	switch task.Overlap {
+	case "":
+		task.Overlap = overlapSkip
+	case overlapSkip, overlapQueue, overlapAllow, overlapCancelPrevious:
+	default:
+		return fmt.Errorf("unknown overlap policy %q", task.Overlap)
+	}
+	return nil
+}
+
+// claimExecution registers an execution for the task's current occurrence
+// and returns it together with the executions still running before it.
+// It returns nil when the occurrence is already being executed, which
+// happens while the scheduler keeps seeing a task that has not been
+// rescheduled yet.
+func claimExecution(task Task) (*execution, []*execution) {
+	inflight.Lock()
+	defer inflight.Unlock()
+
+	previous := inflight.byTask[task.ID]
+	for _, e := range previous {
+		if e.start == task.Start {
+			return nil, nil
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	exec := &execution{taskID: task.ID, start: task.Start, ctx: ctx, cancel: cancel, done: make(chan struct{})}
+	inflight.byTask[task.ID] = append(previous, exec)
+	inflightExecutions.Inc()
+	return exec, append([]*execution(nil), previous...)
+}
+
+// release removes a finished execution from the registry and wakes up any
+// execution queued behind it
+func (e *execution) release() {
+	inflight.Lock()
+	defer inflight.Unlock()
+
+	list := inflight.byTask[e.taskID]
+	for i, other := range list {
+		if other == e {
+			list = append(list[:i], list[i+1:]...)
+			break
+		}
+	}
+	if len(list) == 0 {
+		delete(inflight.byTask, e.taskID)
+	} else {
+		inflight.byTask[e.taskID] = list
+	}
+	inflightExecutions.Dec()
+	e.cancel()
+	close(e.done)
+}
+
+// inflightTotal returns the number of unfinished executions of all tasks
+func inflightTotal() int {
+	inflight.Lock()
+	defer inflight.Unlock()
+	n := 0
+	for _, list := range inflight.byTask {
+		n += len(list)
+	}
+	return n
+}
+
+// cancelExecutions cancels every unfinished execution
+func cancelExecutions() {
+	inflight.Lock()
+	defer inflight.Unlock()
+	for _, list := range inflight.byTask {
+		for _, e := range list {
+			e.cancel()
+		}
+	}
+}
+
+// cancelTaskExecutions cancels the unfinished executions of a task, e.g.
+// once it is deleted or disabled, and returns how many there were
+func cancelTaskExecutions(taskID int) int {
+	inflight.Lock()
+	defer inflight.Unlock()
+	for _, e := range inflight.byTask[taskID] {
+		e.cancel()
+	}
+	return len(inflight.byTask[taskID])
+}
+
+// inflightCount returns the number of unfinished executions of a task
+func inflightCount(taskID int) int {
+	inflight.Lock()
+	defer inflight.Unlock()
+	return len(inflight.byTask[taskID])
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+// Errors returned when the worker pool cannot accept a task
+var (
+	errQueueFull  = errors.New("worker queue is full")
+	errUserLimit  = errors.New("user concurrency limit reached")
+	errPoolClosed = errors.New("worker pool is shut down")
+)
+
+// pool runs the due tasks picked up by the scheduler and the manual runs.
+// main creates it before the server and the scheduler start.
+var pool *workerPool
+
+// poolKey identifies one occurrence of a task, or its manual run
+type poolKey struct {
+	taskID int
+	start  int64
+	manual bool
+}
+
+// poolJob is an execution waiting in the queue
+type poolJob struct {
+	key    poolKey
+	userID int
+	run    func()
+	drop   func() // Called instead of run when the pool shut down first; may be nil
+}
+
+// poolStats is a snapshot of the worker pool state
+type poolStats struct {
+	Workers           int    `json:"workers"`
+	Running           int    `json:"running"`
+	QueueDepth        int    `json:"queue_depth"`
+	QueueCapacity     int    `json:"queue_capacity"`
+	RejectedQueueFull uint64 `json:"rejected_queue_full"`
+	RejectedUserLimit uint64 `json:"rejected_user_limit"`
+}
+
+// workerPool executes tasks on a fixed number of workers fed by a bounded
+// queue, limiting how many executions a single user may have at once
+type workerPool struct {
+	queue     chan poolJob
+	workers   int
+	userLimit int        // Queued plus running executions allowed per user; 0 disables the limit
+	execute   func(Task) // Runs one task occurrence, executeTask outside tests
+
+	mu                sync.Mutex
+	pending           map[poolKey]bool // Occurrences queued or running
+	perUser           map[int]int      // Queued plus running executions per user
+	running           int
+	closed            bool // Set on shutdown; queued tasks are dropped
+	rejectedQueueFull uint64
+	rejectedUserLimit uint64
+}
+
+// newWorkerPool creates a pool whose workers run tasks with execute and
+// starts them
+func newWorkerPool(workers, queueSize, userLimit int, execute func(Task)) *workerPool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	p := &workerPool{
+		queue:     make(chan poolJob, queueSize),
+		workers:   workers,
+		userLimit: userLimit,
+		execute:   execute,
+		pending:   make(map[poolKey]bool),
+		perUser:   make(map[int]int),
+	}
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// submit queues a task occurrence for execution. Occurrences that are
+// already queued or running are accepted without being queued twice.
+func (p *workerPool) submit(task Task) error {
+	job := poolJob{
+		key:    poolKey{taskID: task.ID, start: task.Start},
+		userID: task.UserID,
+		run:    func() { p.execute(task) },
+	}
+	return p.enqueue(job, nil)
+}
+
+// submitManual queues a manual run of the task, performed by run. drop is
+// called instead when the pool shuts down before the run starts. A task
+// has at most one manual run queued or running.
+func (p *workerPool) submitManual(task Task, run, drop func()) error {
+	job := poolJob{
+		key:    poolKey{taskID: task.ID, manual: true},
+		userID: task.UserID,
+		run:    run,
+		drop:   drop,
+	}
+	return p.enqueue(job, errAlreadyRunning)
+}
+
+// enqueue queues a job within the limits of the pool. A job whose key is
+// already queued or running is answered with duplicate.
+func (p *workerPool) enqueue(job poolJob, duplicate error) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return errPoolClosed
+	}
+	if p.pending[job.key] {
+		return duplicate
+	}
+	if p.userLimit > 0 && p.perUser[job.userID] >= p.userLimit {
+		p.rejectedUserLimit++
+		return errUserLimit
+	}
+
+	select {
+	case p.queue <- job:
+	default:
+		p.rejectedQueueFull++
+		return errQueueFull
+	}
+	p.pending[job.key] = true
+	p.perUser[job.userID]++
+	return nil
+}
+
+// work executes queued jobs until the queue is closed. Tasks still queued
+// after shutdown are dropped; they are picked up again after a restart.
+func (p *workerPool) work() {
+	for job := range p.queue {
+		p.mu.Lock()
+		closed := p.closed
+		if !closed {
+			p.running++
+		}
+		p.mu.Unlock()
+
+		if !closed {
+			job.run()
+		} else if job.drop != nil {
+			job.drop()
+		}
+
+		p.mu.Lock()
+		if !closed {
+			p.running--
+		}
+		delete(p.pending, job.key)
+		if p.perUser[job.userID]--; p.perUser[job.userID] <= 0 {
+			delete(p.perUser, job.userID)
+		}
+		p.mu.Unlock()
+	}
+}
+
+// close stops the pool from accepting or starting executions. Executions
+// already running are not interrupted.
+func (p *workerPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+}
+
+// isClosed reports whether the pool was shut down
+func (p *workerPool) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+// stats returns a snapshot of the pool state
+func (p *workerPool) stats() poolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return poolStats{
+		Workers:           p.workers,
+		Running:           p.running,
+		QueueDepth:        len(p.queue),
+		QueueCapacity:     cap(p.queue),
+		RejectedQueueFull: p.rejectedQueueFull,
+		RejectedUserLimit: p.rejectedUserLimit,
+	}
+}
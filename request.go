@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+)
+
+// allowedMethods are the HTTP methods a task may use
+var allowedMethods = map[string]bool{
+	http.MethodGet:    true,
+	http.MethodHead:   true,
+	http.MethodPost:   true,
+	http.MethodPut:    true,
+	http.MethodPatch:  true,
+	http.MethodDelete: true,
+}
+
+// bodyTemplateFuncs are available in body templates, e.g. {{json .Message}}
+// to embed a value as a quoted JSON string.
+var bodyTemplateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// bodyTemplateData is the data a body template is executed with. The task
+// fields are promoted, so {{.Name}} and {{.Message}} refer to the task.
+type bodyTemplateData struct {
+	Task
+	ScheduledAt int64 // Planned start of the run (Unix timestamp)
+	Attempt     int   // Attempt number, starting at 1
+}
+
+// Value stores the map as JSON.
+func (m StringMap) Value() (driver.Value, error) {
+	if m == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan reads a map stored by Value. Empty columns yield a nil map.
+func (m *StringMap) Scan(src interface{}) error {
+	var b []byte
+	switch v := src.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	default:
+		return fmt.Errorf("unsupported string map type %T", src)
+	}
+	if len(b) == 0 {
+		*m = nil
+		return nil
+	}
+	return json.Unmarshal(b, m)
+}
+
+// parseBodyTemplate compiles a task's body template.
+func parseBodyTemplate(body string) (*template.Template, error) {
+	return template.New("body").Funcs(bodyTemplateFuncs).Option("missingkey=error").Parse(body)
+}
+
+// validateRequest checks the HTTP request definition of a task and
+// normalises its method.
+func validateRequest(task *Task) validationErrors {
+	var errs validationErrors
+	task.Method = strings.ToUpper(strings.TrimSpace(task.Method))
+	if task.Method == "" {
+		task.Method = http.MethodGet
+	}
+	if !allowedMethods[task.Method] {
+		errs.add("method", "unsupported method %q", task.Method)
+	}
+	for name := range task.Headers {
+		if name == "" || strings.ContainsAny(name, " :\r\n") {
+			errs.add("headers", "invalid header name %q", name)
+			break
+		}
+	}
+	// Executing the template once catches unknown fields, which parsing alone
+	// does not
+	tmpl, err := parseBodyTemplate(task.Body)
+	if err == nil {
+		err = tmpl.Execute(io.Discard, bodyTemplateData{Task: *task, ScheduledAt: task.Start, Attempt: 1})
+	}
+	if err != nil {
+		errs.add("body", "invalid template: %v", err)
+	}
+	return errs
+}
+
+// buildRequest creates the HTTP request for one attempt of a task run
+func buildRequest(task Task, run TaskRun) (*http.Request, error) {
+	target, err := url.Parse(task.URL)
+	if err != nil {
+		return nil, err
+	}
+	if len(task.Query) > 0 {
+		query := target.Query()
+		for key, value := range task.Query {
+			query.Set(key, value)
+		}
+		target.RawQuery = query.Encode()
+	}
+
+	var body bytes.Buffer
+	if task.Body != "" {
+		tmpl, err := parseBodyTemplate(task.Body)
+		if err != nil {
+			return nil, err
+		}
+		data := bodyTemplateData{Task: task, ScheduledAt: run.ScheduledAt, Attempt: run.Attempt}
+		if err := tmpl.Execute(&body, data); err != nil {
+			return nil, err
+		}
+	}
+
+	method := task.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	req, err := http.NewRequest(method, target.String(), &body)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range task.Headers {
+		req.Header.Set(name, value)
+	}
+	if body.Len() > 0 && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
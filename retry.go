@@ -0,0 +1,121 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// Defaults and bounds for task retry policies
+const (
+	defaultRetryDelayMs    = 1000
+	defaultRetryMultiplier = 2
+	maxRetryAttempts       = 10
+	maxRetryDelayMs        = 60 * 60 * 1000
+)
+
+// Value stores the policy as JSON in the tasks table.
+func (p RetryPolicy) Value() (driver.Value, error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan reads a policy stored by Value. Empty columns yield the zero policy.
+func (p *RetryPolicy) Scan(src interface{}) error {
+	var b []byte
+	switch v := src.(type) {
+	case nil:
+		*p = RetryPolicy{}
+		return nil
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	default:
+		return fmt.Errorf("unsupported retry policy type %T", src)
+	}
+	if len(b) == 0 {
+		*p = RetryPolicy{}
+		return nil
+	}
+	return json.Unmarshal(b, p)
+}
+
+// validate reports the first invalid setting of the policy.
+func (p RetryPolicy) validate() error {
+	switch {
+	case p.MaxAttempts < 0 || p.MaxAttempts > maxRetryAttempts:
+		return fmt.Errorf("max_attempts must be between 0 and %d", maxRetryAttempts)
+	case p.InitialDelayMs < 0 || p.InitialDelayMs > maxRetryDelayMs:
+		return fmt.Errorf("initial_delay_ms must be between 0 and %d", maxRetryDelayMs)
+	case p.MaxDelayMs < 0 || p.MaxDelayMs > maxRetryDelayMs:
+		return fmt.Errorf("max_delay_ms must be between 0 and %d", maxRetryDelayMs)
+	case p.Multiplier != 0 && p.Multiplier < 1:
+		return errors.New("multiplier must be at least 1")
+	case p.Jitter < 0 || p.Jitter > 1:
+		return errors.New("jitter must be between 0 and 1")
+	}
+	for _, code := range p.RetryableCodes {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("invalid retryable status code %d", code)
+		}
+	}
+	return nil
+}
+
+// withDefaults fills in the unset fields of the policy.
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts < 1 {
+		p.MaxAttempts = 1
+	}
+	if p.InitialDelayMs == 0 {
+		p.InitialDelayMs = defaultRetryDelayMs
+	}
+	if p.Multiplier == 0 {
+		p.Multiplier = defaultRetryMultiplier
+	}
+	if p.MaxDelayMs == 0 {
+		p.MaxDelayMs = maxRetryDelayMs
+	}
+	return p
+}
+
+// retryable reports whether a failed run should be attempted again.
+// Requests that got no response are retried after transport errors and
+// timeouts, but not when the request or its body template is invalid.
+func (p RetryPolicy) retryable(run TaskRun) bool {
+	if run.StatusCode == 0 {
+		return !run.permanent
+	}
+	if len(p.RetryableCodes) == 0 {
+		return run.StatusCode == http.StatusRequestTimeout ||
+			run.StatusCode == http.StatusTooManyRequests ||
+			run.StatusCode >= 500
+	}
+	for _, code := range p.RetryableCodes {
+		if code == run.StatusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// delay returns how long to wait after the given failed attempt, growing
+// exponentially from InitialDelayMs, spread by Jitter and capped at
+// MaxDelayMs.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	ms := float64(p.InitialDelayMs) * math.Pow(p.Multiplier, float64(attempt-1))
+	if p.Jitter > 0 {
+		ms *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+	ms = math.Min(ms, float64(p.MaxDelayMs))
+	return time.Duration(ms) * time.Millisecond
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Run outcomes stored in task_runs.status
+const (
+	runStatusSuccess   = "success"
+	runStatusFailure   = "failure"
+	runStatusTimeout   = "timeout"
+	runStatusSkipped   = "skipped"
+	runStatusCancelled = "cancelled"
+	runStatusMisfired  = "misfired"
+	runStatusRunning   = "running" // Asynchronous manual run not finished yet
+)
+
+// What started a run, stored in task_runs.trigger
+const (
+	runTriggerSchedule = "schedule"
+	runTriggerManual   = "manual"
+)
+
+// maxRunResponseBytes limits how much of a response body is kept per run
+const maxRunResponseBytes = 4096
+
+// Page size limits for the run history endpoint
+const (
+	defaultRunsLimit = 50
+	maxRunsLimit     = 500
+)
+
+// runRetentionDays is how long task runs are kept; 0 keeps them forever.
+// It is set from the configuration by startRunPruner.
+var runRetentionDays = 30
+
+// runColumns lists the task_runs columns in the order expected by scanRun.
+const runColumns = "id, task_id, user_id, scheduled_at, started_at, duration_ms, status_code, status, response_body, error, attempt, trigger"
+
+// scanRun reads a row selected with runColumns into a TaskRun.
+func scanRun(row rowScanner) (TaskRun, error) {
+	var run TaskRun
+	err := row.Scan(&run.ID, &run.TaskID, &run.UserID, &run.ScheduledAt, &run.StartedAt, &run.DurationMs, &run.StatusCode, &run.Status, &run.ResponseBody, &run.Error, &run.Attempt, &run.Trigger)
+	return run, err
+}
+
+// recordRun stores a task run in the history table and returns its ID. A
+// run with an ID replaces the row recorded before, e.g. a running
+// placeholder. Finished runs are counted in the metrics.
+func recordRun(run TaskRun) (int, error) {
+	if run.Trigger == "" {
+		run.Trigger = runTriggerSchedule
+	}
+	observeRun(run)
+	if run.ID != 0 {
+		_, err := db.Exec(`UPDATE task_runs SET scheduled_at = ?, started_at = ?, duration_ms = ?, status_code = ?, status = ?, response_body = ?, error = ?, attempt = ?, trigger = ?
+			WHERE id = ?`,
+			run.ScheduledAt, run.StartedAt, run.DurationMs, run.StatusCode, run.Status, run.ResponseBody, run.Error, run.Attempt, run.Trigger, run.ID)
+		return run.ID, err
+	}
+	result, err := db.Exec(`INSERT INTO task_runs(task_id, user_id, scheduled_at, started_at, duration_ms, status_code, status, response_body, error, attempt, trigger)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		run.TaskID, run.UserID, run.ScheduledAt, run.StartedAt, run.DurationMs, run.StatusCode, run.Status, run.ResponseBody, run.Error, run.Attempt, run.Trigger)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// pruneRuns deletes task runs that started before the retention period.
+func pruneRuns(now time.Time) (int64, error) {
+	if runRetentionDays <= 0 {
+		return 0, nil
+	}
+	cutoff := now.AddDate(0, 0, -runRetentionDays).Unix()
+	result, err := db.Exec("DELETE FROM task_runs WHERE started_at < ?", cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// startRunPruner periodically removes task runs older than the retention
+// period until ctx is cancelled
+func startRunPruner(ctx context.Context, retentionDays int) {
+	runRetentionDays = retentionDays
+
+	for {
+		deleted, err := pruneRuns(time.Now())
+		if err != nil {
+			logx.Println("Error pruning task runs:", err)
+		} else if deleted > 0 {
+			logx.Printf("Pruned %d task runs older than %d days\n", deleted, runRetentionDays)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(1 * time.Hour):
+		}
+	}
+}
+
+// taskRunsHandler returns the execution history of a task, newest first.
+// It supports limit/offset pagination and a comma-separated status filter.
+func taskRunsHandler(c *fiber.Ctx) error {
+	storedUser := User{ID: currentUserID(c)}
+
+	taskID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid task ID"})
+	}
+
+	limit := c.QueryInt("limit", defaultRunsLimit)
+	offset := c.QueryInt("offset", 0)
+	if limit <= 0 || limit > maxRunsLimit || offset < 0 {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid pagination parameters"})
+	}
+
+	// Team members see every run of the task. Runs of deleted tasks are
+	// only visible to the user who owned the task.
+	where := "task_id = ?"
+	args := []interface{}{taskID}
+	if _, err := authorizeTask(taskID, storedUser.ID, roleViewer); err == errTaskNotFound {
+		var exists bool
+		if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM tasks WHERE id = ?)", taskID).Scan(&exists); err != nil {
+			logx.Println("Error checking task ID:", taskID, "Error:", err)
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve runs"})
+		}
+		if exists {
+			return taskAccessError(c, errTaskNotFound) // The task is no longer shared with the user
+		}
+		where += " AND user_id = ?"
+		args = append(args, storedUser.ID)
+	} else if err != nil {
+		return taskAccessError(c, err)
+	}
+	if status := c.Query("status"); status != "" {
+		statuses := strings.Split(status, ",")
+		where += " AND status IN (?" + strings.Repeat(", ?", len(statuses)-1) + ")"
+		for _, s := range statuses {
+			args = append(args, strings.TrimSpace(s))
+		}
+	}
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM task_runs WHERE "+where, args...).Scan(&total); err != nil {
+		logx.Println("Error counting runs for task ID:", taskID, "Error:", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve runs"})
+	}
+
+	rows, err := db.Query("SELECT "+runColumns+" FROM task_runs WHERE "+where+" ORDER BY started_at DESC, id DESC LIMIT ? OFFSET ?", append(args, limit, offset)...)
+	if err != nil {
+		logx.Println("Error retrieving runs for task ID:", taskID, "Error:", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve runs"})
+	}
+	defer rows.Close()
+
+	runs := []TaskRun{}
+	for rows.Next() {
+		run, err := scanRun(rows)
+		if err != nil {
+			logx.Println("Error scanning run for task ID:", taskID, "Error:", err)
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to scan runs"})
+		}
+		runs = append(runs, run)
+	}
+
+	return c.JSON(fiber.Map{
+		"runs":   runs,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// runTaskHandler executes a task once, outside its schedule, through the
+// worker pool. By default it waits for the result of a single attempt; with
+// ?async=true it answers 202 with the ID of the run, whose status is running
+// until it finishes, and the task's retry policy applies.
+func runTaskHandler(c *fiber.Ctx, taskID int) error {
+	storedUser := User{ID: currentUserID(c)}
+	task, err := authorizeTask(taskID, storedUser.ID, roleEditor)
+	if err != nil {
+		return taskAccessError(c, err)
+	}
+	logx.Printf("Manual run of task ID %d requested by user ID %d\n", taskID, storedUser.ID)
+
+	if c.QueryBool("async") {
+		now := time.Now().Unix()
+		runID, err := recordRun(TaskRun{TaskID: task.ID, UserID: task.UserID, ScheduledAt: now, StartedAt: now, Status: runStatusRunning, Attempt: 1, Trigger: runTriggerManual})
+		if err != nil {
+			logx.Println("Error recording run for task ID:", task.ID, err)
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to start run"})
+		}
+		skip := func(err error) {
+			skipped := TaskRun{ID: runID, TaskID: task.ID, UserID: task.UserID, ScheduledAt: now, StartedAt: now, Status: runStatusSkipped, Error: err.Error(), Attempt: 1, Trigger: runTriggerManual}
+			if _, err := recordRun(skipped); err != nil {
+				logx.Println("Error recording run for task ID:", task.ID, err)
+			}
+		}
+		err = pool.submitManual(task, func() {
+			if _, err := runTaskNow(task, runID); err != nil {
+				skip(err)
+			}
+		}, func() { skip(errPoolClosed) })
+		if err != nil {
+			if _, err := db.Exec("DELETE FROM task_runs WHERE id = ?", runID); err != nil {
+				logx.Println("Error deleting run ID:", runID, err)
+			}
+			return manualRunError(c, task, err)
+		}
+		return c.Status(http.StatusAccepted).JSON(fiber.Map{"message": "Task run started", "run_id": runID})
+	}
+
+	// The request waits for the run, so failures are not retried
+	task.Retry.MaxAttempts = 1
+	type result struct {
+		runs []TaskRun
+		err  error
+	}
+	done := make(chan result, 1)
+	err = pool.submitManual(task, func() {
+		runs, err := runTaskNow(task, 0)
+		done <- result{runs, err}
+	}, func() { done <- result{err: errPoolClosed} })
+	if err != nil {
+		return manualRunError(c, task, err)
+	}
+	r := <-done
+	if r.err != nil {
+		return manualRunError(c, task, r.err)
+	}
+	return c.JSON(fiber.Map{
+		"message": "Task executed",
+		"status":  r.runs[len(r.runs)-1].Status,
+		"runs":    r.runs,
+	})
+}
+
+// manualRunError answers a manual run that could not start
+func manualRunError(c *fiber.Ctx, task Task, err error) error {
+	logx.Printf("Manual run of task ID %d refused: %v\n", task.ID, err)
+	switch err {
+	case errAlreadyRunning:
+		return c.Status(http.StatusConflict).JSON(fiber.Map{"error": "Task is already running"})
+	case errQueueFull, errUserLimit:
+		return c.Status(http.StatusTooManyRequests).JSON(fiber.Map{"error": "Too many executions queued, try again later"})
+	case errPoolClosed:
+		return c.Status(http.StatusServiceUnavailable).JSON(fiber.Map{"error": "Server is shutting down"})
+	}
+	return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to run task"})
+}
@@ -1,83 +1,402 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"io"
 	"net/http"
 	"time"
+
+	"github.com/robfig/cron/v3"
 )
 
-// startTaskScheduler continuously checks for tasks to execute
-func startTaskScheduler() {
-	for {
-		time.Sleep(1 * time.Second) // Wait for 1 second before the next check
-		now := time.Now().Unix()
+// cronParser accepts standard 5-field expressions, an optional leading
+// seconds field and descriptors such as @hourly or @daily.
+var cronParser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
 
-		// Query for tasks that are due to be executed
-		rows, err := db.Query("SELECT id, user_id, message, url, interval, start, end, is_recurring, enabled FROM tasks WHERE start <= ? AND ((is_recurring = 0 AND end >= ?) OR (is_recurring = 1 AND end >= ?))", now, now, now)
-		if err != nil {
-			logx.Println("Error querying tasks:", err)
-			continue
-		}
+// parseSchedule validates a cron expression and returns its schedule.
+func parseSchedule(spec string) (cron.Schedule, error) {
+	return cronParser.Parse(spec)
+}
 
-		var tasks []Task
-		for rows.Next() {
-			var task Task
-			if err := rows.Scan(&task.ID, &task.UserID, &task.Message, &task.URL, &task.Interval, &task.Start, &task.End, &task.IsRecurring, &task.Enabled); err != nil {
-				logx.Println("Error scanning task:", err)
-				continue
-			}
-			if task.Enabled { // Only execute if the task is enabled
-				tasks = append(tasks, task)
-			}
-		}
-		rows.Close()
+// taskLocation returns the time zone a task's schedule is evaluated in.
+// Tasks without a time zone run in UTC.
+func taskLocation(task Task) (*time.Location, error) {
+	if task.Timezone == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(task.Timezone)
+}
 
-		// Execute tasks concurrently
-		for _, task := range tasks {
-			go executeTask(task)
-		}
+// nextStart computes when a recurring task should run next after from.
+// Tasks with a cron schedule follow it in the task's time zone, so wall
+// clock times survive DST transitions; the others add their interval.
+func nextStart(task Task, from time.Time) (int64, error) {
+	next, err := nextStartFunc(task)
+	if err != nil {
+		return 0, err
 	}
+	return next(from), nil
 }
 
-// executeTask performs the HTTP GET request for the task
-func executeTask(task Task) {
-	logx.Printf("Executing task ID %d: %s at %s\n", task.ID, task.Message, time.Now().Format(time.RFC3339))
+// nextStartFunc is nextStart for repeated use: it parses the task's
+// schedule and time zone once and returns the computation.
+func nextStartFunc(task Task) (func(from time.Time) int64, error) {
+	if task.Schedule == "" {
+		return func(from time.Time) int64 { return from.Unix() + task.Interval }, nil
+	}
+	schedule, err := parseSchedule(task.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := taskLocation(task)
+	if err != nil {
+		return nil, err
+	}
+	return func(from time.Time) int64 { return schedule.Next(from.In(loc)).Unix() }, nil
+}
+
+// runAttempt performs a single HTTP request for the task and describes its
+// outcome as a TaskRun
+func runAttempt(parent context.Context, task Task, attempt int) (run TaskRun) {
+	started := time.Now()
+	run = TaskRun{
+		TaskID:      task.ID,
+		UserID:      task.UserID,
+		ScheduledAt: task.Start,
+		StartedAt:   started.Unix(),
+		Attempt:     attempt,
+		Status:      runStatusFailure,
+	}
+	defer func() { run.DurationMs = time.Since(started).Milliseconds() }()
 
-	// Perform the HTTP GET request
-	resp, err := http.Get(task.URL)
+	req, err := buildRequest(task, run)
 	if err != nil {
-		logx.Printf("Error making GET request for task ID %d: %v\n", task.ID, err)
-		return
+		logx.Printf("Error building request for task ID %d: %v\n", task.ID, err)
+		run.Error = err.Error()
+		run.permanent = true
+		return run
+	}
+
+	// Perform the HTTP request, bounded by the task's timeout
+	ctx, cancel := context.WithTimeout(parent, taskTimeout(task))
+	defer cancel()
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		logx.Printf("Error making %s request for task ID %d: %v\n", req.Method, task.ID, err)
+		run.Error = err.Error()
+		run.Status = failureStatus(parent, err)
+		return run
 	}
 	defer resp.Body.Close()
+	run.StatusCode = resp.StatusCode
+
+	// Keep at most maxRunResponseBytes of the body for the run history
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRunResponseBytes))
+	run.ResponseBody = string(body)
+	if err != nil {
+		logx.Printf("Error reading response body for task ID %d: %v\n", task.ID, err)
+		run.Error = err.Error()
+		if status := failureStatus(parent, err); status != runStatusFailure {
+			run.Status = status
+			return run
+		}
+	}
 
 	// Check the response status
 	if resp.StatusCode == http.StatusOK {
+		run.Status = runStatusSuccess
 		logx.Printf("Task ID %d completed: Status %s\n", task.ID, resp.Status)
 	} else {
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			logx.Printf("Error reading response body for task ID %d: %v\n", task.ID, err)
+		logx.Printf("Task ID %d failed: Status %s, Response: %s\n", task.ID, resp.Status, run.ResponseBody)
+	}
+	return run
+}
+
+// failureStatus classifies a request error as a cancellation of the
+// execution, a timeout or a plain failure
+func failureStatus(parent context.Context, err error) string {
+	switch {
+	case parent.Err() == context.Canceled:
+		return runStatusCancelled
+	case isTimeout(err):
+		return runStatusTimeout
+	default:
+		return runStatusFailure
+	}
+}
+
+// startTaskScheduler loads the enabled tasks into the in-memory queue and
+// hands each one to the worker pool p when its start time arrives. The loop
+// sleeps until the earliest start time or until the queue changes, and
+// returns when ctx is cancelled.
+func startTaskScheduler(ctx context.Context, p *workerPool, config SchedulerConfig) {
+	if err := scheduler.load(); err != nil {
+		logx.Println("Error loading tasks:", err)
+	}
+
+	timer := time.NewTimer(0)
+	resync := time.NewTicker(config.ResyncInterval)
+	defer resync.Stop()
+	heartbeat := time.NewTicker(heartbeatInterval) // Keeps the readiness heartbeat fresh while idle
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			logx.Println("Task scheduler stopped")
 			return
+		case <-timer.C:
+		case <-heartbeat.C:
+		case <-scheduler.wake:
+		case <-resync.C:
+			if err := scheduler.load(); err != nil {
+				logx.Println("Error reloading tasks:", err)
+			}
+		}
+		started := time.Now()
+		beat(started)
+		dispatchDueTasks(p, started, config.RetryDelay)
+		schedulerLoopDuration.Observe(time.Since(started).Seconds())
+		timer.Reset(scheduler.untilNext(time.Now()))
+	}
+}
+
+// dispatchDueTasks submits every task whose start time has passed to the
+// worker pool. Tasks the pool rejects are offered again after retryDelay.
+func dispatchDueTasks(p *workerPool, now time.Time, retryDelay time.Duration) {
+	var queueFull, userLimit int
+	for {
+		taskID, ok := scheduler.popDue(now.Unix())
+		if !ok {
+			break
+		}
+
+		task, err := scanTask(db.QueryRow("SELECT "+taskColumns+" FROM tasks WHERE id = ?", taskID))
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			logx.Println("Error loading task ID:", taskID, err)
+			scheduler.upsert(taskID, now.Add(retryDelay).Unix())
+			continue
+		}
+		if !task.Enabled || ended(task.End, now) {
+			continue
+		}
+		if task.Start > now.Unix() {
+			scheduler.upsert(task.ID, task.Start) // Stale entry, the task moved
+			continue
+		}
+
+		switch p.submit(task) {
+		case errQueueFull:
+			queueFull++
+			scheduler.upsert(task.ID, now.Add(retryDelay).Unix())
+		case errUserLimit:
+			userLimit++
+			scheduler.upsert(task.ID, now.Add(retryDelay).Unix())
 		}
-		logx.Printf("Task ID %d failed: Status %s, Response: %s\n", task.ID, resp.Status, string(body))
 	}
 
-	// Handle recurring and non-recurring tasks
+	if queueFull > 0 || userLimit > 0 {
+		stats := p.stats()
+		logx.Printf("Worker pool rejected %d tasks (queue full: %d, user limit: %d), queue depth %d/%d, running %d/%d\n",
+			queueFull+userLimit, queueFull, userLimit, stats.QueueDepth, stats.QueueCapacity, stats.Running, stats.Workers)
+	}
+}
+
+// executeTask performs the HTTP request for the task with retries,
+// records each attempt and reschedules or removes the task. The task's
+// overlap policy decides what happens while a previous execution is running
+// and its misfire policy how a late task catches up.
+func executeTask(task Task) {
+	exec, previous := claimExecution(task)
+	if exec == nil {
+		return // This occurrence is already being executed
+	}
+	defer exec.release()
+
+	// Move recurring tasks to their next occurrence right away, so a slow
+	// execution is not picked up again by the scheduler
 	if task.IsRecurring {
-		newStart := time.Now().Unix() + task.Interval
-		_, err := db.Exec("UPDATE tasks SET start = ? WHERE id = ?", newStart, task.ID)
-		if err != nil {
-			logx.Println("Error rescheduling task ID:", task.ID, err)
-		} else {
-			logx.Printf("Task ID %d rescheduled to start at %d\n", task.ID, newStart)
+		rescheduleTask(task)
+	}
+
+	if _, ok := resolveOverlap(task, previous, runTriggerSchedule, 0); !ok {
+		return
+	}
+
+	// Catch up according to the misfire policy when the task is late
+	occurrences, note := planOccurrences(task, time.Now())
+	if note != "" {
+		logx.Printf("Task ID %d %s\n", task.ID, note)
+		misfired := TaskRun{
+			TaskID:      task.ID,
+			UserID:      task.UserID,
+			ScheduledAt: task.Start,
+			StartedAt:   time.Now().Unix(),
+			Status:      runStatusMisfired,
+			Error:       note,
 		}
-	} else {
+		if _, err := recordRun(misfired); err != nil {
+			logx.Println("Error recording run for task ID:", task.ID, err)
+		}
+	}
+	for _, start := range occurrences {
+		occurrence := task
+		occurrence.Start = start
+		runWithRetries(exec, occurrence, runTriggerSchedule, 0)
+		if exec.ctx.Err() != nil {
+			break
+		}
+	}
+
+	// One-shot tasks are removed once executed. A cancelled execution, e.g.
+	// at the shutdown deadline, never reached its outcome, so the task is
+	// kept and runs again after a restart.
+	if exec.ctx.Err() != nil {
+		logx.Printf("Task ID %d was cancelled before its outcome\n", task.ID)
+		return
+	}
+	if !task.IsRecurring {
 		_, err := db.Exec("DELETE FROM tasks WHERE id = ?", task.ID)
 		if err != nil {
 			logx.Println("Error deleting task ID:", task.ID, err)
 		} else {
 			logx.Printf("Task ID %d deleted after execution\n", task.ID)
 		}
+		scheduler.remove(task.ID)
+	}
+}
+
+// runTaskNow executes a task once, outside its schedule, and returns its
+// runs. It shares the overlap handling and retries of scheduled executions
+// but leaves the task's start untouched. A non-zero runID is the recorded
+// placeholder reused for the first run.
+func runTaskNow(task Task, runID int) ([]TaskRun, error) {
+	task.Start = time.Now().Unix()
+	exec, previous := claimExecution(task)
+	if exec == nil {
+		return nil, errAlreadyRunning
+	}
+	defer exec.release()
+
+	if skipped, ok := resolveOverlap(task, previous, runTriggerManual, runID); !ok {
+		return []TaskRun{skipped}, nil
+	}
+	return runWithRetries(exec, task, runTriggerManual, runID), nil
+}
+
+// resolveOverlap applies the task's overlap policy to the executions still
+// running. It returns false, with the recorded skipped run, when this
+// execution must not run.
+func resolveOverlap(task Task, previous []*execution, trigger string, runID int) (TaskRun, bool) {
+	if len(previous) == 0 {
+		return TaskRun{}, true
+	}
+	switch {
+	case task.Overlap == overlapAllow:
+	case task.Overlap == overlapCancelPrevious:
+		logx.Printf("Cancelling %d running execution(s) of task ID %d\n", len(previous), task.ID)
+		for _, e := range previous {
+			e.cancel()
+		}
+	case task.Overlap == overlapQueue && len(previous) <= maxQueuedExecutions:
+		logx.Printf("Task ID %d queued behind a running execution\n", task.ID)
+		for _, e := range previous {
+			<-e.done
+		}
+	default:
+		logx.Printf("Task ID %d skipped: previous execution still running\n", task.ID)
+		skipped := TaskRun{
+			ID:          runID,
+			TaskID:      task.ID,
+			UserID:      task.UserID,
+			ScheduledAt: task.Start,
+			StartedAt:   time.Now().Unix(),
+			Status:      runStatusSkipped,
+			Error:       "skipped due to overlap: previous execution still running",
+			Attempt:     1,
+			Trigger:     trigger,
+		}
+		var err error
+		if skipped.ID, err = recordRun(skipped); err != nil {
+			logx.Println("Error recording run for task ID:", task.ID, err)
+		}
+		return skipped, false
+	}
+	return TaskRun{}, true
+}
+
+// runWithRetries executes one occurrence of a task, retrying failed attempts
+// according to the task's policy and recording each one. A non-zero runID is
+// the recorded placeholder reused for the first attempt.
+func runWithRetries(exec *execution, task Task, trigger string, runID int) []TaskRun {
+	loc, err := taskLocation(task)
+	if err != nil {
+		loc = time.UTC
+	}
+	logx.Printf("Executing task ID %d: %s at %s\n", task.ID, task.Message, time.Now().In(loc).Format(time.RFC3339))
+
+	var runs []TaskRun
+	policy := task.Retry.withDefaults()
+	if trigger == runTriggerSchedule {
+		taskScheduleLag.Observe(time.Since(time.Unix(task.Start, 0)).Seconds())
+	}
+	for attempt := 1; ; attempt++ {
+		run := runAttempt(exec.ctx, task, attempt)
+		run.Trigger = trigger
+		if attempt == 1 {
+			run.ID = runID
+		}
+		var err error
+		if run.ID, err = recordRun(run); err != nil {
+			logx.Println("Error recording run for task ID:", task.ID, err)
+		}
+		runs = append(runs, run)
+		if run.Status == runStatusSuccess || run.Status == runStatusCancelled || attempt >= policy.MaxAttempts || !policy.retryable(run) {
+			return runs
+		}
+		delay := policy.delay(attempt)
+		logx.Printf("Retrying task ID %d in %s (attempt %d of %d)\n", task.ID, delay, attempt+1, policy.MaxAttempts)
+		select {
+		case <-time.After(delay):
+		case <-exec.ctx.Done():
+		}
+		if !taskRunnable(task.ID, trigger) {
+			logx.Printf("Task ID %d was deleted or disabled, no more retries\n", task.ID)
+			return runs
+		}
+	}
+}
+
+// taskRunnable reports whether a task may run another attempt: it must
+// still exist and, unless run manually, be enabled. Database errors do not
+// stop the retries.
+func taskRunnable(taskID int, trigger string) bool {
+	var enabled bool
+	err := db.QueryRow("SELECT enabled FROM tasks WHERE id = ?", taskID).Scan(&enabled)
+	if err == sql.ErrNoRows {
+		return false
+	} else if err != nil {
+		logx.Println("Error checking task ID:", taskID, err)
+		return true
+	}
+	return enabled || trigger == runTriggerManual
+}
+
+// rescheduleTask moves a recurring task to its next start time
+func rescheduleTask(task Task) {
+	newStart, err := nextStart(task, time.Now())
+	if err != nil {
+		logx.Println("Error computing next start for task ID:", task.ID, err)
+		return
+	}
+	_, err = db.Exec("UPDATE tasks SET start = ? WHERE id = ?", newStart, task.ID)
+	if err != nil {
+		logx.Println("Error rescheduling task ID:", task.ID, err)
+	} else {
+		logx.Printf("Task ID %d rescheduled to start at %d\n", task.ID, newStart)
 	}
+	scheduler.refresh(task.ID)
 }
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// defaultShutdownTimeout bounds how long shutdown waits for in-flight
+// executions. It can be overridden with server.shutdown_timeout.
+const defaultShutdownTimeout = 30 * time.Second
+
+// cancelGrace is how long cancelled executions get to record their runs
+const cancelGrace = 5 * time.Second
+
+// drainPollInterval is how often shutdown checks for finished executions
+const drainPollInterval = 50 * time.Millisecond
+
+// waitForExecutions waits until no task execution is in flight or the
+// context is done. It returns the number of executions still running.
+func waitForExecutions(ctx context.Context) int {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		n := inflightTotal()
+		if n == 0 {
+			return 0
+		}
+		select {
+		case <-ctx.Done():
+			return n
+		case <-ticker.C:
+		}
+	}
+}
+
+// shutdown stops the server in order: the scheduler loop and background
+// jobs (through stopScheduler), the worker pool and the HTTP server stop
+// taking new work, in-flight executions drain until the deadline, after
+// which they are cancelled, and the database is closed last.
+func shutdown(app *fiber.App, stopScheduler context.CancelFunc, timeout time.Duration) {
+	deadline, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	stopScheduler()
+	if pool != nil {
+		pool.close()
+	}
+	if err := app.ShutdownWithContext(deadline); err != nil {
+		logx.Println("Error shutting down HTTP server:", err)
+	}
+
+	if n := waitForExecutions(deadline); n > 0 {
+		logx.Printf("Cancelling %d task execution(s) still running after %s\n", n, timeout)
+		cancelExecutions()
+		grace, cancelGraceWait := context.WithTimeout(context.Background(), cancelGrace)
+		defer cancelGraceWait()
+		if n := waitForExecutions(grace); n > 0 {
+			logx.Printf("%d task execution(s) did not stop in time\n", n)
+		}
+	}
+
+	if err := db.Close(); err != nil {
+		logx.Println("Error closing database:", err)
+	}
+	logx.Println("Server stopped")
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Page size limits for the task list
+const (
+	defaultTasksLimit = 50
+	maxTasksLimit     = 500
+)
+
+// taskSorts maps the sort options of the task list to columns
+var taskSorts = map[string]string{
+	"id":       "id",
+	"name":     "name",
+	"next_run": "start",
+	"end":      "end",
+}
+
+// taskCursor marks the last task of a page. It is sent to clients as
+// opaque base64-encoded JSON.
+type taskCursor struct {
+	Sort  string      `json:"s"`
+	Value interface{} `json:"v"`
+	ID    int         `json:"id"`
+}
+
+func encodeCursor(cursor taskCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(encoded string) (taskCursor, error) {
+	var cursor taskCursor
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(data, &cursor)
+	return cursor, err
+}
+
+// sortValue returns the value of the sort column of a task
+func sortValue(task Task, sort string) interface{} {
+	switch sort {
+	case "name":
+		return task.Name
+	case "next_run":
+		return task.Start
+	case "end":
+		return task.End
+	}
+	return task.ID
+}
+
+// taskListQuery is the SQL for one page of the task list
+type taskListQuery struct {
+	where   []string
+	args    []interface{}
+	sort    string
+	desc    bool
+	limit   int
+	cursor  *taskCursor
+	orderBy string
+}
+
+// parseTaskListQuery reads the filters, sort and page of a task list
+// request. Tasks are visible to their owner and to members of their team.
+func parseTaskListQuery(c *fiber.Ctx, userID int) (taskListQuery, error) {
+	q := taskListQuery{
+		where: []string{"((team_id = 0 AND user_id = ?) OR team_id IN (SELECT team_id FROM team_members WHERE user_id = ?))"},
+		args:  []interface{}{userID, userID},
+		limit: c.QueryInt("limit", defaultTasksLimit),
+	}
+	if q.limit <= 0 || q.limit > maxTasksLimit {
+		return q, fmt.Errorf("limit must be between 1 and %d", maxTasksLimit)
+	}
+
+	for param, column := range map[string]string{"enabled": "enabled", "recurring": "is_recurring"} {
+		if value := c.Query(param); value != "" {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return q, fmt.Errorf("%s must be true or false", param)
+			}
+			q.where = append(q.where, column+" = ?")
+			q.args = append(q.args, b)
+		}
+	}
+
+	if prefix := c.Query("name_prefix"); prefix != "" {
+		q.where = append(q.where, "substr(name, 1, ?) = ?")
+		q.args = append(q.args, utf8.RuneCountInString(prefix), prefix)
+	}
+
+	if host := strings.ToLower(c.Query("url_host")); host != "" {
+		// Match the host followed by the end of the URL, a port, path or query
+		escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(host)
+		q.where = append(q.where, `(url LIKE ? ESCAPE '\' OR url LIKE ? ESCAPE '\' OR url LIKE ? ESCAPE '\' OR url LIKE ? ESCAPE '\')`)
+		q.args = append(q.args, "%://"+escaped, "%://"+escaped+":%", "%://"+escaped+"/%", "%://"+escaped+"?%")
+	}
+
+	for param, op := range map[string]string{"next_run_before": "<", "next_run_after": ">"} {
+		if value := c.Query(param); value != "" {
+			ts, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return q, fmt.Errorf("%s must be a Unix timestamp", param)
+			}
+			q.where = append(q.where, "start "+op+" ?")
+			q.args = append(q.args, ts)
+		}
+	}
+
+	q.sort = c.Query("sort", "id")
+	if strings.HasPrefix(q.sort, "-") {
+		q.sort, q.desc = q.sort[1:], true
+	}
+	column, ok := taskSorts[q.sort]
+	if !ok {
+		return q, fmt.Errorf("sort must be one of id, name, next_run or end, optionally prefixed with -")
+	}
+	direction := "ASC"
+	if q.desc {
+		direction = "DESC"
+	}
+	q.orderBy = column + " " + direction + ", id " + direction
+
+	if encoded := c.Query("cursor"); encoded != "" {
+		cursor, err := decodeCursor(encoded)
+		if err != nil || cursor.Sort != c.Query("sort", "id") {
+			return q, errors.New("invalid cursor")
+		}
+		if f, ok := cursor.Value.(float64); ok {
+			cursor.Value = int64(f) // Numbers decode as float64
+		}
+		q.cursor = &cursor
+	}
+	return q, nil
+}
+
+// page returns the SQL selecting one page, one task past the limit to tell
+// whether another page follows.
+func (q taskListQuery) page() (string, []interface{}) {
+	where, args := q.where, q.args
+	if q.cursor != nil {
+		column, op := taskSorts[q.sort], ">"
+		if q.desc {
+			op = "<"
+		}
+		where = append(where[:len(where):len(where)], fmt.Sprintf("(%s %s ? OR (%s = ? AND id %s ?))", column, op, column, op))
+		args = append(args[:len(args):len(args)], q.cursor.Value, q.cursor.Value, q.cursor.ID)
+	}
+	query := "SELECT " + taskColumns + " FROM tasks WHERE " + strings.Join(where, " AND ") + " ORDER BY " + q.orderBy + " LIMIT ?"
+	return query, append(args, q.limit+1)
+}
+
+// count returns the SQL counting every task matching the filters
+func (q taskListQuery) count() (string, []interface{}) {
+	return "SELECT COUNT(*) FROM tasks WHERE " + strings.Join(q.where, " AND "), q.args
+}
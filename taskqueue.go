@@ -0,0 +1,187 @@
+package main
+
+import (
+	"container/heap"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// idleWait is how long the scheduler sleeps when no task is queued
+const idleWait = time.Hour
+
+// ended reports whether a task's end time has passed. An end of 0 means
+// the task never ends.
+func ended(end int64, now time.Time) bool {
+	return end != 0 && end < now.Unix()
+}
+
+// scheduler holds the next fire time of every enabled task
+var scheduler = newTaskQueue()
+
+// queueItem is a task waiting for its next start time
+type queueItem struct {
+	taskID int
+	start  int64 // Next start time (Unix timestamp)
+	index  int   // Position in the heap
+}
+
+// fireHeap is a min-heap of queue items ordered by start time
+type fireHeap []*queueItem
+
+func (h fireHeap) Len() int { return len(h) }
+func (h fireHeap) Less(i, j int) bool {
+	if h[i].start == h[j].start {
+		return h[i].taskID < h[j].taskID
+	}
+	return h[i].start < h[j].start
+}
+func (h fireHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *fireHeap) Push(x interface{}) {
+	item := x.(*queueItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+func (h *fireHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return item
+}
+
+// taskQueue keeps tasks ordered by their next start time and wakes the
+// scheduler loop whenever the earliest one changes
+type taskQueue struct {
+	mu    sync.Mutex
+	heap  fireHeap
+	items map[int]*queueItem
+	wake  chan struct{}
+}
+
+func newTaskQueue() *taskQueue {
+	return &taskQueue{
+		items: make(map[int]*queueItem),
+		wake:  make(chan struct{}, 1),
+	}
+}
+
+// notify wakes the scheduler loop without blocking
+func (q *taskQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// upsert queues a task at the given start time, replacing any previous entry
+func (q *taskQueue) upsert(taskID int, start int64) {
+	q.mu.Lock()
+	if item, ok := q.items[taskID]; ok {
+		item.start = start
+		heap.Fix(&q.heap, item.index)
+	} else {
+		item := &queueItem{taskID: taskID, start: start}
+		heap.Push(&q.heap, item)
+		q.items[taskID] = item
+	}
+	q.mu.Unlock()
+	q.notify()
+}
+
+// remove drops a task from the queue
+func (q *taskQueue) remove(taskID int) {
+	q.mu.Lock()
+	if item, ok := q.items[taskID]; ok {
+		heap.Remove(&q.heap, item.index)
+		delete(q.items, taskID)
+	}
+	q.mu.Unlock()
+	q.notify()
+}
+
+// refresh re-reads a task from the database after it was created, updated,
+// enabled, disabled or rescheduled, and queues or drops it accordingly
+func (q *taskQueue) refresh(taskID int) {
+	var start, end int64
+	var enabled bool
+	err := db.QueryRow("SELECT start, end, enabled FROM tasks WHERE id = ?", taskID).Scan(&start, &end, &enabled)
+	if err != nil && err != sql.ErrNoRows {
+		logx.Println("Error refreshing task ID:", taskID, err)
+	}
+	if err != nil || !enabled || ended(end, time.Now()) {
+		q.remove(taskID)
+		return
+	}
+	q.upsert(taskID, start)
+}
+
+// load rebuilds the queue from every enabled task that has not ended
+func (q *taskQueue) load() error {
+	rows, err := db.Query("SELECT id, start FROM tasks WHERE enabled = 1 AND (end = 0 OR end >= ?)", time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	h := fireHeap{}
+	items := make(map[int]*queueItem)
+	for rows.Next() {
+		item := &queueItem{}
+		if err := rows.Scan(&item.taskID, &item.start); err != nil {
+			return err
+		}
+		item.index = len(h)
+		h = append(h, item)
+		items[item.taskID] = item
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	heap.Init(&h)
+
+	q.mu.Lock()
+	q.heap, q.items = h, items
+	q.mu.Unlock()
+	q.notify()
+	return nil
+}
+
+// popDue removes and returns the earliest task due at now, if any
+func (q *taskQueue) popDue(now int64) (int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.heap) == 0 || q.heap[0].start > now {
+		return 0, false
+	}
+	item := heap.Pop(&q.heap).(*queueItem)
+	delete(q.items, item.taskID)
+	return item.taskID, true
+}
+
+// next returns the earliest queued start time
+func (q *taskQueue) next() (int64, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.heap) == 0 {
+		return 0, false
+	}
+	return q.heap[0].start, true
+}
+
+// untilNext returns how long the loop can sleep before the next start time
+func (q *taskQueue) untilNext(now time.Time) time.Duration {
+	start, ok := q.next()
+	if !ok {
+		return idleWait
+	}
+	wait := time.Unix(start, 0).Sub(now)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Team membership roles, from most to least privileged
+const (
+	roleOwner  = "owner"  // Manage members and tasks
+	roleEditor = "editor" // Create, pause and delete tasks
+	roleViewer = "viewer" // See tasks and their runs
+)
+
+// roleRank orders roles so a higher role includes the rights of lower ones
+var roleRank = map[string]int{roleViewer: 1, roleEditor: 2, roleOwner: 3}
+
+var (
+	errTaskNotFound = errors.New("task not found")
+	errTeamNotFound = errors.New("team not found")
+	errForbidden    = errors.New("insufficient role")
+	errLastOwner    = errors.New("team needs an owner")
+)
+
+// teamRole returns the role of a user in a team, or "" when they are not a
+// member. Admins own every existing team.
+func teamRole(teamID, userID int) (string, error) {
+	var role string
+	err := db.QueryRow(`SELECT COALESCE(m.role, '') FROM teams t
+		LEFT JOIN team_members m ON m.team_id = t.id AND m.user_id = ? WHERE t.id = ?`, userID, teamID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	if role == roleOwner {
+		return role, nil
+	}
+	admin, err := isAdmin(userID)
+	if err != nil {
+		return "", err
+	}
+	if admin {
+		return roleOwner, nil
+	}
+	return role, nil
+}
+
+// hasRole reports whether role grants at least the rights of need
+func hasRole(role, need string) bool {
+	return role != "" && roleRank[role] >= roleRank[need]
+}
+
+// taskRole returns the role of a user over a task. Users own their personal
+// tasks, and admins those of everyone; team tasks take the user's role in the
+// team.
+func taskRole(task Task, userID int) (string, error) {
+	if task.TeamID == 0 {
+		if task.UserID == userID {
+			return roleOwner, nil
+		}
+		if admin, err := isAdmin(userID); err != nil || !admin {
+			return "", err
+		}
+		return roleOwner, nil
+	}
+	return teamRole(task.TeamID, userID)
+}
+
+// authorizeTask loads a task and checks that the user holds at least the
+// needed role over it. Tasks the user cannot see are reported as not found.
+func authorizeTask(taskID, userID int, need string) (Task, error) {
+	task, err := scanTask(db.QueryRow("SELECT "+taskColumns+" FROM tasks WHERE id = ?", taskID))
+	if err == sql.ErrNoRows {
+		return Task{}, errTaskNotFound
+	} else if err != nil {
+		return Task{}, err
+	}
+	role, err := taskRole(task, userID)
+	if err != nil {
+		return Task{}, err
+	}
+	if role == "" {
+		return Task{}, errTaskNotFound
+	}
+	if !hasRole(role, need) {
+		return Task{}, errForbidden
+	}
+	return task, nil
+}
+
+// taskAccessError maps an authorizeTask error to a response
+func taskAccessError(c *fiber.Ctx, err error) error {
+	switch err {
+	case errTaskNotFound:
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{"error": "Task not found"})
+	case errForbidden:
+		return c.Status(http.StatusForbidden).JSON(fiber.Map{"error": "Insufficient role for this task"})
+	}
+	logx.Println("Error checking task access:", err)
+	return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to check task access"})
+}
+
+// listTeamsHandler lists the teams of the authenticated user with their role
+func listTeamsHandler(c *fiber.Ctx) error {
+	userID := currentUserID(c)
+	rows, err := db.Query(`SELECT t.id, t.name, t.created_at, m.role FROM teams t
+		JOIN team_members m ON m.team_id = t.id WHERE m.user_id = ? ORDER BY t.id`, userID)
+	if err != nil {
+		logx.Println("Error retrieving teams for user ID:", userID, "Error:", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve teams"})
+	}
+	defer rows.Close()
+
+	teams := []Team{}
+	for rows.Next() {
+		var team Team
+		if err := rows.Scan(&team.ID, &team.Name, &team.CreatedAt, &team.Role); err != nil {
+			logx.Println("Error scanning team for user ID:", userID, "Error:", err)
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to scan teams"})
+		}
+		teams = append(teams, team)
+	}
+	return c.JSON(fiber.Map{"teams": teams})
+}
+
+// createTeamHandler creates a team owned by the authenticated user
+func createTeamHandler(c *fiber.Ctx) error {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		logx.Println("Error parsing request body in createTeamHandler:", err)
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Name is required"})
+	}
+
+	userID := currentUserID(c)
+	team := Team{Name: req.Name, CreatedAt: time.Now().Unix(), Role: roleOwner}
+
+	tx, err := db.Begin()
+	if err != nil {
+		logx.Println("Error starting transaction in createTeamHandler:", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	var existing int
+	err = tx.QueryRow("SELECT id FROM teams WHERE name = ?", team.Name).Scan(&existing)
+	if err == nil {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{"error": "Team with the same name already exists"})
+	} else if err != sql.ErrNoRows {
+		logx.Println("Error checking for existing team:", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to check for existing team"})
+	}
+
+	if err := tx.QueryRow("INSERT INTO teams(name, created_at) VALUES(?, ?) RETURNING id", team.Name, team.CreatedAt).Scan(&team.ID); err != nil {
+		logx.Println("Error creating team:", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create team"})
+	}
+	if _, err := tx.Exec("INSERT INTO team_members(team_id, user_id, role) VALUES(?, ?, ?)", team.ID, userID, roleOwner); err != nil {
+		logx.Println("Error adding team owner:", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create team"})
+	}
+	if err := tx.Commit(); err != nil {
+		logx.Println("Error committing transaction in createTeamHandler:", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to commit transaction"})
+	}
+
+	logx.Printf("Team %d (%s) created by user ID %d\n", team.ID, team.Name, userID)
+	return c.JSON(fiber.Map{"message": "Team created successfully", "team": team})
+}
+
+// teamMembersHandler lists the members of a team the user belongs to, or of
+// any team for admins
+func teamMembersHandler(c *fiber.Ctx) error {
+	teamID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid team ID"})
+	}
+	if role, err := teamRole(teamID, currentUserID(c)); err != nil {
+		return teamAccessError(c, err)
+	} else if role == "" {
+		return teamAccessError(c, errTeamNotFound)
+	}
+
+	rows, err := db.Query(`SELECT m.user_id, u.username, m.role FROM team_members m
+		JOIN users u ON u.id = m.user_id WHERE m.team_id = ? ORDER BY m.user_id`, teamID)
+	if err != nil {
+		logx.Println("Error retrieving members of team ID:", teamID, "Error:", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve members"})
+	}
+	defer rows.Close()
+
+	members := []TeamMember{}
+	for rows.Next() {
+		var member TeamMember
+		if err := rows.Scan(&member.UserID, &member.Username, &member.Role); err != nil {
+			logx.Println("Error scanning member of team ID:", teamID, "Error:", err)
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to scan members"})
+		}
+		members = append(members, member)
+	}
+	return c.JSON(fiber.Map{"members": members})
+}
+
+// setTeamMemberHandler adds a user to a team or changes their role. Only
+// owners can manage members.
+func setTeamMemberHandler(c *fiber.Ctx) error {
+	teamID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid team ID"})
+	}
+	var req struct {
+		Username string `json:"username"`
+		Role     string `json:"role"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		logx.Println("Error parsing request body in setTeamMemberHandler:", err)
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+	}
+	if _, ok := roleRank[req.Role]; !ok {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid role: must be owner, editor or viewer"})
+	}
+
+	if err := checkTeamOwner(teamID, currentUserID(c)); err != nil {
+		return teamAccessError(c, err)
+	}
+
+	var userID int
+	err = db.QueryRow("SELECT id FROM users WHERE username = ?", req.Username).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+	} else if err != nil {
+		logx.Println("Error looking up user in setTeamMemberHandler:", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to look up user"})
+	}
+	if req.Role != roleOwner {
+		if err := checkOtherOwner(teamID, userID); err != nil {
+			return teamAccessError(c, err)
+		}
+	}
+
+	_, err = db.Exec(`INSERT INTO team_members(team_id, user_id, role) VALUES(?, ?, ?)
+		ON CONFLICT(team_id, user_id) DO UPDATE SET role = excluded.role`, teamID, userID, req.Role)
+	if err != nil {
+		logx.Println("Error setting team member:", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to set team member"})
+	}
+
+	logx.Printf("User ID %d set to %s in team %d by user ID %d\n", userID, req.Role, teamID, currentUserID(c))
+	return c.JSON(fiber.Map{"message": "Team member updated successfully", "member": TeamMember{UserID: userID, Username: req.Username, Role: req.Role}})
+}
+
+// removeTeamMemberHandler removes a user from a team. Only owners can
+// manage members.
+func removeTeamMemberHandler(c *fiber.Ctx) error {
+	teamID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid team ID"})
+	}
+	userID, err := c.ParamsInt("user_id")
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	if err := checkTeamOwner(teamID, currentUserID(c)); err != nil {
+		return teamAccessError(c, err)
+	}
+	if err := checkOtherOwner(teamID, userID); err != nil {
+		return teamAccessError(c, err)
+	}
+
+	result, err := db.Exec("DELETE FROM team_members WHERE team_id = ? AND user_id = ?", teamID, userID)
+	if err != nil {
+		logx.Println("Error removing team member:", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to remove team member"})
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{"error": "Team member not found"})
+	}
+
+	logx.Printf("User ID %d removed from team %d by user ID %d\n", userID, teamID, currentUserID(c))
+	return c.JSON(fiber.Map{"message": "Team member removed successfully"})
+}
+
+// checkTeamOwner returns an error unless the user owns the team
+func checkTeamOwner(teamID, userID int) error {
+	role, err := teamRole(teamID, userID)
+	if err != nil {
+		return err
+	}
+	if role == "" {
+		return errTeamNotFound
+	}
+	if role != roleOwner {
+		return errForbidden
+	}
+	return nil
+}
+
+// checkOtherOwner returns errLastOwner when userID is the team's only owner,
+// so a team is never left without one.
+func checkOtherOwner(teamID, userID int) error {
+	var owners int
+	err := db.QueryRow("SELECT COUNT(*) FROM team_members WHERE team_id = ? AND role = ? AND user_id != ?", teamID, roleOwner, userID).Scan(&owners)
+	if err != nil {
+		return err
+	}
+	if owners == 0 {
+		return errLastOwner
+	}
+	return nil
+}
+
+// teamAccessError maps a team membership check error to a response
+func teamAccessError(c *fiber.Ctx, err error) error {
+	switch err {
+	case errTeamNotFound:
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{"error": "Team not found"})
+	case errForbidden:
+		return c.Status(http.StatusForbidden).JSON(fiber.Map{"error": "Only team owners can manage members"})
+	case errLastOwner:
+		return c.Status(http.StatusConflict).JSON(fiber.Map{"error": "A team needs at least one owner"})
+	}
+	logx.Println("Error checking team membership:", err)
+	return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to check team membership"})
+}
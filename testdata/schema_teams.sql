@@ -0,0 +1,96 @@
+-- A database created by the release that added teams, the last one before
+-- migrations existed. It has every table but task_runs lacks trigger.
+CREATE TABLE users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username TEXT UNIQUE,
+    token TEXT,
+    token_prefix TEXT,
+    token_hash TEXT,
+    is_admin BOOLEAN DEFAULT FALSE
+);
+
+CREATE INDEX idx_users_token_prefix ON users(token_prefix);
+
+CREATE TABLE api_keys (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER,
+    name TEXT,
+    key_prefix TEXT,
+    key_hash TEXT,
+    scopes TEXT,
+    created_at INTEGER,
+    expires_at INTEGER DEFAULT 0,
+    last_used_at INTEGER DEFAULT 0,
+    revoked_at INTEGER DEFAULT 0,
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    UNIQUE(user_id, name)
+);
+
+CREATE INDEX idx_api_keys_prefix ON api_keys(key_prefix);
+
+CREATE TABLE teams (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT UNIQUE,
+    created_at INTEGER
+);
+
+CREATE TABLE team_members (
+    team_id INTEGER,
+    user_id INTEGER,
+    role TEXT,
+    FOREIGN KEY (team_id) REFERENCES teams(id),
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    PRIMARY KEY (team_id, user_id)
+);
+
+CREATE TABLE tasks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER,
+    name TEXT,
+    message TEXT,
+    url TEXT,
+    interval INTEGER,
+    start INTEGER,
+    end INTEGER,
+    is_recurring BOOLEAN,
+    enabled BOOLEAN DEFAULT FALSE,
+    schedule TEXT DEFAULT '',
+    timezone TEXT DEFAULT 'UTC',
+    retry_policy TEXT DEFAULT '{}',
+    method TEXT DEFAULT 'GET',
+    headers TEXT DEFAULT '{}',
+    query TEXT DEFAULT '{}',
+    body TEXT DEFAULT '',
+    timeout INTEGER DEFAULT 0,
+    overlap TEXT DEFAULT 'skip',
+    misfire TEXT DEFAULT 'fire_once',
+    misfire_threshold INTEGER DEFAULT 0,
+    misfire_limit INTEGER DEFAULT 0,
+    team_id INTEGER DEFAULT 0,
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    UNIQUE(user_id, name)
+);
+
+CREATE TABLE task_runs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    task_id INTEGER,
+    user_id INTEGER,
+    scheduled_at INTEGER,
+    started_at INTEGER,
+    duration_ms INTEGER,
+    status_code INTEGER,
+    status TEXT,
+    response_body TEXT,
+    error TEXT,
+    attempt INTEGER
+);
+
+CREATE INDEX idx_task_runs_task ON task_runs(task_id, started_at);
+
+INSERT INTO users (id, username, token_prefix, token_hash, is_admin) VALUES (1, 'admin', 'abcdefgh', 'salt:hash', 1);
+INSERT INTO teams (id, name, created_at) VALUES (1, 'ops', 1700000000);
+INSERT INTO team_members (team_id, user_id, role) VALUES (1, 1, 'owner');
+INSERT INTO tasks (id, user_id, name, message, url, interval, start, end, is_recurring, enabled, method, team_id)
+VALUES (7, 1, 'Team task', 'Ping', 'https://example.com/ping', 3600, 1700000000, 0, 1, 1, 'POST', 1);
+INSERT INTO task_runs (task_id, user_id, scheduled_at, started_at, duration_ms, status_code, status, response_body, error, attempt)
+VALUES (7, 1, 1700000000, 1700000001, 120, 200, 'success', 'pong', '', 1);
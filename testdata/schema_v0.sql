@@ -0,0 +1,25 @@
+-- A database created by the first release, before migrations existed
+CREATE TABLE users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username TEXT UNIQUE,
+    token TEXT
+);
+
+CREATE TABLE tasks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER,
+    name TEXT,
+    message TEXT,
+    url TEXT,
+    interval INTEGER,
+    start INTEGER,
+    end INTEGER,
+    is_recurring BOOLEAN,
+    enabled BOOLEAN DEFAULT FALSE,  -- Default value for Enabled
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    UNIQUE(user_id, name)  -- Ensure task name is unique per user
+);
+
+INSERT INTO users (id, username, token) VALUES (1, 'legacy', 'legacy-token'), (2, 'a', '123');
+INSERT INTO tasks (id, user_id, name, message, url, interval, start, end, is_recurring, enabled)
+VALUES (1, 1, 'Legacy task', 'Ping', 'https://example.com/ping', 3600, 1700000000, 1800000000, 1, 1);
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Task validation bounds
+const (
+	maxNameLength = 100
+	minInterval   = 1                  // Seconds between runs of an interval task
+	maxInterval   = 366 * 24 * 60 * 60 // One year, in seconds
+)
+
+// taskNamePattern allows letters, digits, spaces and a few separators
+var taskNamePattern = regexp.MustCompile(`^[\p{L}\p{N}][\p{L}\p{N} _.:#()/-]*$`)
+
+// fieldError describes why one field of a request is invalid
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validationErrors collects every invalid field of a request. The handlers
+// return them with 422 Unprocessable Entity.
+type validationErrors []fieldError
+
+func (v validationErrors) Error() string {
+	messages := make([]string, len(v))
+	for i, e := range v {
+		messages[i] = e.Field + ": " + e.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (v *validationErrors) add(field, format string, args ...interface{}) {
+	*v = append(*v, fieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// validateTask checks the name, URL, interval and time window of a task
+// after prepareTask has applied its defaults. An end of 0 leaves the task
+// open-ended. It returns nil for a valid task.
+func validateTask(task Task) validationErrors {
+	var errs validationErrors
+
+	name := strings.TrimSpace(task.Name)
+	switch {
+	case name == "":
+		errs.add("name", "is required")
+	case utf8.RuneCountInString(task.Name) > maxNameLength:
+		errs.add("name", "must be at most %d characters", maxNameLength)
+	case name != task.Name || !taskNamePattern.MatchString(task.Name):
+		errs.add("name", "may only contain letters, digits, spaces and _ . : # ( ) / -")
+	}
+
+	if task.URL == "" {
+		errs.add("url", "is required")
+	} else if target, err := url.Parse(task.URL); err != nil {
+		errs.add("url", "is not a valid URL")
+	} else if target.Scheme != "http" && target.Scheme != "https" {
+		errs.add("url", "must use the http or https scheme")
+	} else if target.Hostname() == "" {
+		errs.add("url", "must include a host")
+	}
+
+	if task.Interval < 0 || task.Interval > maxInterval {
+		errs.add("interval", "must be between 0 and %d seconds", maxInterval)
+	} else if task.IsRecurring && task.Schedule == "" && task.Interval < minInterval {
+		errs.add("interval", "must be at least %d second for recurring tasks without a schedule", minInterval)
+	}
+
+	if task.Start < 0 {
+		errs.add("start", "must not be negative")
+	}
+	if task.End < 0 {
+		errs.add("end", "must not be negative")
+	} else if task.End != 0 && task.End <= task.Start {
+		errs.add("end", "must be after start")
+	} else if ended(task.End, time.Now()) {
+		errs.add("end", "must be in the future, or 0 for no end")
+	}
+
+	return errs
+}